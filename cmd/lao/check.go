@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+// check parses and checks the files, or the standard input when there are
+// none, and prints every problem found as file:line:column: severity:
+// message. It returns the exit status, 1 when an error was found.
+func check(files []string, out io.Writer) int {
+	if len(files) == 0 {
+		source, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		return checkSource("<stdin>", source, out)
+	}
+
+	status := 0
+	for _, file := range files {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(out, err)
+			status = 1
+			continue
+		}
+
+		if checkSource(file, source, out) != 0 {
+			status = 1
+		}
+	}
+	return status
+}
+
+func checkSource(name string, source []byte, out io.Writer) int {
+	statements, err := lao.NewParser(
+		lao.NewTokenizer(bytes.NewReader(source)),
+		lao.WithRecovery(),
+	).Parse()
+	if err != nil {
+		var parseErrors lao.ParseErrors
+		if !errors.As(err, &parseErrors) {
+			fmt.Fprintf(out, "%s: %s\n", name, err)
+			return 1
+		}
+
+		for _, e := range parseErrors {
+			fmt.Fprintf(out, "%s:%d:%d: error: %s\n", name, e.Line, e.Column, e.Err)
+		}
+		return 1
+	}
+
+	status := 0
+	for _, diagnostic := range lao.Check(statements) {
+		fmt.Fprintf(out, "%s:%s\n", name, diagnostic)
+		if diagnostic.Severity == lao.SeverityError {
+			status = 1
+		}
+	}
+	return status
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+const debugHelp = `Commands:
+  break <line|label>  pause before the statements of a line, or after a label
+  break               list the breakpoints
+  clear <line>        remove the breakpoint on a line
+  step, s             run the next statement, going into gosubs and calls
+  next, n             run to the next line, stepping over gosubs and calls
+  continue, c         run until a breakpoint
+  print, p [name]     show a variable, or every variable
+  set <name> <value>  change a variable
+  line, l             show the current line
+  help                show this message
+  quit, q             stop the program
+An empty line repeats step or next.`
+
+// errQuit stops the program being debugged.
+var errQuit = errors.New("quit")
+
+// debug runs the program in file paused before its first statement, and
+// reads commands from in to set breakpoints, step through it and look at
+// its variables. Read statements share the same input as the commands. It
+// returns the exit status, 1 when the program could not be read or failed.
+func debug(args []string, in io.Reader, out, errs io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(errs, "usage: lao debug <path_to_program>")
+		return 2
+	}
+
+	source, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintln(errs, err)
+		return 1
+	}
+
+	statements, err := lao.NewParser(
+		lao.NewTokenizer(bytes.NewReader(source)),
+		lao.WithRecovery(),
+	).Parse()
+	if err != nil {
+		var parseErrors lao.ParseErrors
+		if errors.As(err, &parseErrors) {
+			printParseErrors(errs, source, parseErrors)
+		} else {
+			fmt.Fprintln(errs, err)
+		}
+		return 1
+	}
+
+	input := bufio.NewReader(in)
+	d := &debugger{source: source, input: input, out: out}
+	d.session = lao.NewSession(statements, d.stop)
+
+	var interpreter lao.Interpreter
+	{
+		interpreter = lao.NewInterpreter(out, lao.WithInput(input), lao.WithDebugger(d.session))
+	}
+
+	err = interpreter.Execute(statements)
+	if errors.Is(err, errQuit) {
+		return 0
+	}
+	if err != nil && err != io.EOF {
+		var runtimeError *lao.RuntimeError
+		if errors.As(err, &runtimeError) {
+			printRuntimeError(errs, source, runtimeError)
+		} else {
+			fmt.Fprintln(errs, err)
+		}
+		return 1
+	}
+
+	fmt.Fprintln(out, "program finished")
+	return 0
+}
+
+// debugger is the command line front-end of a debugging session.
+type debugger struct {
+	session lao.Session
+	source  []byte
+	input   *bufio.Reader
+	out     io.Writer
+	last    string // the last step or next, repeated by an empty line
+}
+
+// stop shows where the program paused and reads commands until one of them
+// lets it go on.
+func (d *debugger) stop(p lao.Pause) error {
+	line, column := p.Position()
+	printSourceLine(d.out, d.source, line, column)
+
+	for {
+		fmt.Fprint(d.out, "(lao) ")
+		command, err := d.input.ReadString('\n')
+		if err != nil && command == "" {
+			fmt.Fprintln(d.out)
+			return errQuit
+		}
+
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			if d.last == "" {
+				continue
+			}
+			fields = []string{d.last}
+		}
+
+		switch fields[0] {
+		case "step", "s":
+			d.last = fields[0]
+			d.session.Step()
+			return nil
+		case "next", "n":
+			d.last = fields[0]
+			d.session.Next()
+			return nil
+		case "continue", "c":
+			d.session.Continue()
+			return nil
+		case "quit", "q":
+			return errQuit
+		case "break", "b":
+			d.setBreakpoint(fields[1:])
+		case "clear":
+			d.clearBreakpoint(fields[1:])
+		case "print", "p":
+			d.print(p, fields[1:])
+		case "set":
+			d.set(p, strings.TrimSpace(command)[len(fields[0]):])
+		case "line", "l":
+			printSourceLine(d.out, d.source, line, column)
+		case "help", "h":
+			fmt.Fprintln(d.out, debugHelp)
+		default:
+			fmt.Fprintf(d.out, "unknown command %s, try help\n", fields[0])
+		}
+	}
+}
+
+func (d *debugger) setBreakpoint(args []string) {
+	switch len(args) {
+	case 0:
+		for _, line := range d.session.Breakpoints() {
+			fmt.Fprintf(d.out, "breakpoint at line %d\n", line)
+		}
+		return
+	case 1:
+	default:
+		fmt.Fprintln(d.out, "usage: break <line|label>")
+		return
+	}
+
+	if line, err := strconv.Atoi(args[0]); err == nil {
+		d.session.Break(line)
+		fmt.Fprintf(d.out, "breakpoint at line %d\n", line)
+		return
+	}
+
+	line, err := d.session.BreakAtLabel(strings.ToLower(args[0]))
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+	fmt.Fprintf(d.out, "breakpoint at line %d\n", line)
+}
+
+func (d *debugger) clearBreakpoint(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "usage: clear <line>")
+		return
+	}
+
+	line, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Fprintf(d.out, "invalid line %s\n", args[0])
+		return
+	}
+	d.session.Clear(line)
+}
+
+func (d *debugger) print(p lao.Pause, args []string) {
+	variables := p.Variables()
+	if len(args) == 0 {
+		printVariables(variables, d.out)
+		return
+	}
+
+	for _, name := range args {
+		name = strings.ToLower(name)
+		value, ok := variables[name]
+		if !ok {
+			fmt.Fprintf(d.out, "no variable named %s\n", name)
+			continue
+		}
+		printVariables(map[string]lao.Value{name: value}, d.out)
+	}
+}
+
+// set changes a variable to a value written as it would be in a program:
+// a number, or a string between double quotes. The value is the rest of
+// the command after the name and an optional =, so strings keep their
+// spaces.
+func (d *debugger) set(p lao.Pause, command string) {
+	command = strings.TrimSpace(command)
+	name, text := command, ""
+	if end := strings.IndexAny(command, " \t="); end >= 0 {
+		name, text = command[:end], strings.TrimSpace(command[end:])
+	}
+	text = strings.TrimSpace(strings.TrimPrefix(text, "="))
+	if name == "" || text == "" {
+		fmt.Fprintln(d.out, "usage: set <name> <value>")
+		return
+	}
+
+	name = strings.ToLower(name)
+
+	t, err := variableType(name, p.Variables())
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+
+	value, err := parseValue(t, text)
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+
+	if err := p.SetVariable(name, value); err != nil {
+		fmt.Fprintln(d.out, err)
+	}
+}
+
+// variableType returns the type of the value a variable holds, or the type
+// its name gives it when it is not defined yet.
+func variableType(name string, variables map[string]lao.Value) (lao.ValueType, error) {
+	if value, ok := variables[name]; ok {
+		return value.Type(), nil
+	}
+
+	statements, err := lao.NewParser(lao.NewTokenizer(strings.NewReader(name + " = 0"))).Parse()
+	if err == nil && len(statements) == 1 {
+		if assignment, ok := statements[0].(lao.AssignmentStatement); ok {
+			switch assignment.Variable.Type {
+			case lao.VariableInteger:
+				return lao.ValueInteger, nil
+			case lao.VariableReal:
+				return lao.ValueReal, nil
+			case lao.VariableString:
+				return lao.ValueString, nil
+			}
+		}
+	}
+
+	return lao.ValueNone, fmt.Errorf("%s is not the name of a variable", name)
+}
+
+func parseValue(t lao.ValueType, text string) (lao.Value, error) {
+	switch t {
+	case lao.ValueInteger:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return lao.Value{}, fmt.Errorf("invalid integer %s", text)
+		}
+		return lao.IntegerValue(n), nil
+	case lao.ValueReal:
+		x, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return lao.Value{}, fmt.Errorf("invalid real %s", text)
+		}
+		return lao.RealValue(x), nil
+	case lao.ValueString:
+		if s, err := strconv.Unquote(text); err == nil {
+			return lao.StringValue(s), nil
+		}
+		return lao.Value{}, fmt.Errorf("strings are written between double quotes, as in %q", text)
+	}
+
+	return lao.Value{}, fmt.Errorf("cannot set a variable holding %s", t)
+}
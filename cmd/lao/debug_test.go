@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugSet(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		program  string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "string with repeated spaces",
+			program:  "w = \"x\"\nprint w",
+			input:    "next\nset w   \"a  b\t c \"\ncontinue\n",
+			expected: "a  b\t c \n",
+		},
+		{
+			desc:     "value after an equals sign",
+			program:  "a = 1\nprint a",
+			input:    "next\nset a = 42\ncontinue\n",
+			expected: "42\n",
+		},
+		{
+			desc:     "equals sign without spaces",
+			program:  "gx = 1.5\nprint gx",
+			input:    "next\nset gx=2.5\ncontinue\n",
+			expected: "2.500000\n",
+		},
+		{
+			desc:     "missing value",
+			program:  "a = 1\nprint a",
+			input:    "next\nset a =\ncontinue\n",
+			expected: "usage: set <name> <value>\n",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "debug-*.lao")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(file.Name())
+			if _, err := file.WriteString(tC.program); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			out, errs := new(bytes.Buffer), new(bytes.Buffer)
+			status := debug([]string{file.Name()}, strings.NewReader(tC.input), out, errs)
+			assert.Equal(t, 0, status, errs.String())
+			assert.Contains(t, out.String(), tC.expected)
+		})
+	}
+}
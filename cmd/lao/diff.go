@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// edit is a line kept, removed or added on the way from one text to
+// another.
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// printDiff prints a unified diff from before to after, or nothing when they
+// are the same.
+func printDiff(w io.Writer, name string, before, after []byte) {
+	if bytes.Equal(before, after) {
+		return
+	}
+
+	edits := diffLines(splitLines(before), splitLines(after))
+
+	fmt.Fprintf(w, "diff %s %s\n", name, name)
+	fmt.Fprintf(w, "--- %s.orig\n", name)
+	fmt.Fprintf(w, "+++ %s\n", name)
+
+	// Lines before the current edit in each text
+	oldLine, newLine := 0, 0
+	for start := 0; start < len(edits); {
+		// Find the next change and the end of its hunk, joining changes
+		// less than two contexts apart
+		first := start
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+
+		last := first
+		for index := first; index < len(edits); index++ {
+			if edits[index].op != ' ' {
+				last = index
+			} else if index-last > 2*diffContext {
+				break
+			}
+		}
+
+		from := first - diffContext
+		if from < start {
+			from = start
+		}
+		to := last + diffContext + 1
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		for _, e := range edits[start:from] {
+			oldLine, newLine = advance(e, oldLine, newLine)
+		}
+
+		oldStart, newStart := oldLine, newLine
+		hunk := new(strings.Builder)
+		for _, e := range edits[from:to] {
+			oldLine, newLine = advance(e, oldLine, newLine)
+			fmt.Fprintf(hunk, "%c%s\n", e.op, e.text)
+		}
+
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(oldStart, oldLine-oldStart), hunkRange(newStart, newLine-newStart))
+		io.WriteString(w, hunk.String())
+
+		start = to
+	}
+}
+
+// advance counts the lines of each text an edit goes past.
+func advance(e edit, oldLine, newLine int) (int, int) {
+	if e.op != '+' {
+		oldLine++
+	}
+	if e.op != '-' {
+		newLine++
+	}
+	return oldLine, newLine
+}
+
+// hunkRange formats the start and length of a hunk, where start is the
+// number of lines before it.
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+func splitLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for index, line := range lines {
+		if strings.HasSuffix(line, "\n") {
+			lines[index] = strings.TrimSuffix(line, "\n")
+		} else {
+			lines[index] = line + "\n\\ No newline at end of file"
+		}
+	}
+	return lines
+}
+
+// diffLines finds the edits from before to after through their longest
+// common subsequence of lines.
+func diffLines(before, after []string) []edit {
+	// common[i][j] is the length of the longest common subsequence of
+	// before[i:] and after[j:]
+	common := make([][]int, len(before)+1)
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			edits = append(edits, edit{' ', before[i]})
+			i++
+			j++
+		case j == len(after) || i < len(before) && common[i+1][j] >= common[i][j+1]:
+			edits = append(edits, edit{'-', before[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', after[j]})
+			j++
+		}
+	}
+	return edits
+}
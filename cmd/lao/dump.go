@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+// tokens prints the tokens of a file, or of the standard input, one per
+// line with their position, kind and value, or as JSON with -json.
+func tokens(args []string, out, errs io.Writer) int {
+	name, source, asJSON, status := readDumpSource("tokens", args, errs)
+	if source == nil {
+		return status
+	}
+
+	tokenizer := lao.NewTokenizer(bytes.NewReader(source), lao.WithTrivia())
+	list := []dumpToken{}
+	for tokenizer.Next() {
+		token := tokenizer.Current()
+		list = append(list, dumpToken{
+			Kind:   token.Kind.String(),
+			Value:  token.Value,
+			Line:   token.Line,
+			Column: token.Column,
+			Offset: token.Offset,
+			Length: token.Length,
+		})
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(list)
+	} else {
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		for _, token := range list {
+			fmt.Fprintf(w, "%d:%d\t%s\t%q\n", token.Line, token.Column, token.Kind, token.Value)
+		}
+		w.Flush()
+	}
+
+	if err := tokenizer.Err(); err != nil {
+		fmt.Fprintf(errs, "%s: %s\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+type dumpToken struct {
+	Kind   string `json:"kind"`
+	Value  string `json:"value"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// ast prints the tree of nodes parsed from a file, or from the standard
+// input, with the type, source span and fields of each node, or as the JSON
+// of lao.MarshalProgram with -json.
+func ast(args []string, out, errs io.Writer) int {
+	name, source, asJSON, status := readDumpSource("ast", args, errs)
+	if source == nil {
+		return status
+	}
+
+	statements, err := lao.NewParser(
+		lao.NewTokenizer(bytes.NewReader(source)),
+		lao.WithRecovery(),
+	).Parse()
+	if err != nil {
+		var parseErrors lao.ParseErrors
+		if !errors.As(err, &parseErrors) {
+			fmt.Fprintf(errs, "%s: %s\n", name, err)
+			return 1
+		}
+
+		for _, e := range parseErrors {
+			fmt.Fprintf(errs, "%s:%d:%d: %s\n", name, e.Line, e.Column, e.Err)
+		}
+		status = 1
+	}
+
+	if asJSON {
+		// The format lao.UnmarshalProgram reads back
+		data, err := lao.MarshalProgram(statements)
+		if err != nil {
+			fmt.Fprintf(errs, "%s: %s\n", name, err)
+			return 1
+		}
+
+		var indented bytes.Buffer
+		json.Indent(&indented, data, "", "  ")
+		indented.WriteString("\n")
+		indented.WriteTo(out)
+		return status
+	}
+
+	for _, statement := range statements {
+		describe(statement).print(out, "", "")
+	}
+	return status
+}
+
+// readDumpSource parses the flags of the tokens and ast commands and reads
+// the file they name, or the standard input. The source is nil when the
+// command should stop with the status.
+func readDumpSource(command string, args []string, errs io.Writer) (string, []byte, bool, int) {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(errs)
+	asJSON := flags.Bool("json", false, "print JSON instead of text")
+	flags.Usage = func() {
+		fmt.Fprintf(errs, "usage: lao %s [-json] [path]\n", command)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		if err == nil {
+			flags.Usage()
+		}
+		return "", nil, false, 2
+	}
+
+	name := "<stdin>"
+	var source []byte
+	var err error
+	if flags.NArg() == 0 {
+		source, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		name = flags.Arg(0)
+		source, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		fmt.Fprintln(errs, err)
+		return "", nil, false, 1
+	}
+
+	return name, source, *asJSON, 0
+}
+
+// dumpNode describes a node: its type, the span of source it was parsed
+// from and its exported fields. Fields holding nodes hold *dumpNode or
+// []*dumpNode, and the rest their value or its name.
+type dumpNode struct {
+	Type   string
+	Start  *dumpPosition
+	End    *dumpPosition
+	Fields []dumpField
+}
+
+// dumpName is the name of a value, such as the type of a variable or an
+// operator, printed without quotes.
+type dumpName string
+
+type dumpField struct {
+	Name  string
+	Value interface{}
+}
+
+type dumpPosition struct {
+	Line   int
+	Column int
+}
+
+var nodeType = reflect.TypeOf((*lao.Node)(nil)).Elem()
+
+// describe walks the exported fields of a node with reflection, so new
+// node types show up without changes here.
+func describe(node lao.Node) *dumpNode {
+	value := reflect.ValueOf(node)
+	d := &dumpNode{Type: value.Type().Name()}
+
+	if tokens := node.Tokens(); len(tokens) > 0 {
+		first, last := tokens[0], tokens[len(tokens)-1]
+		d.Start = &dumpPosition{Line: first.Line, Column: first.Column}
+		d.End = &dumpPosition{Line: last.Line, Column: last.Column + utf8.RuneCountInString(last.Value)}
+	}
+
+	for index := 0; index < value.NumField(); index++ {
+		field := value.Type().Field(index)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if v := describeValue(value.Field(index)); v != nil {
+			d.Fields = append(d.Fields, dumpField{Name: field.Name, Value: v})
+		}
+	}
+	return d
+}
+
+// describeValue returns the description of a field, or nil for empty ones.
+func describeValue(value reflect.Value) interface{} {
+	switch {
+	case value.Kind() == reflect.Interface && value.IsNil():
+		return nil
+	case value.Type().Implements(nodeType) || value.Kind() == reflect.Interface:
+		return describe(value.Interface().(lao.Node))
+	case value.Kind() == reflect.Slice:
+		if value.Len() == 0 {
+			return nil
+		}
+		nodes := []*dumpNode{}
+		for index := 0; index < value.Len(); index++ {
+			if node, ok := value.Index(index).Interface().(lao.Node); ok {
+				nodes = append(nodes, describe(node))
+			}
+		}
+		return nodes
+	case value.Type() == reflect.TypeOf(lao.Token{}):
+		// Tokens are already part of the span
+		return nil
+	}
+
+	if value.IsZero() {
+		return nil
+	}
+	if stringer, ok := value.Interface().(fmt.Stringer); ok {
+		return dumpName(stringer.String())
+	}
+	return value.Interface()
+}
+
+// print writes the node as one line with its span and plain fields,
+// followed by the fields holding nodes indented below it.
+func (n *dumpNode) print(w io.Writer, indent, label string) {
+	line := []string{indent + label + n.Type}
+	if n.Start != nil {
+		line = append(line, fmt.Sprintf("%d:%d-%d:%d", n.Start.Line, n.Start.Column, n.End.Line, n.End.Column))
+	}
+	for _, field := range n.Fields {
+		switch v := field.Value.(type) {
+		case *dumpNode, []*dumpNode:
+		case dumpName:
+			line = append(line, fmt.Sprintf("%s=%s", field.Name, v))
+		case string:
+			line = append(line, fmt.Sprintf("%s=%q", field.Name, v))
+		default:
+			line = append(line, fmt.Sprintf("%s=%v", field.Name, v))
+		}
+	}
+	fmt.Fprintln(w, strings.Join(line, " "))
+
+	for _, field := range n.Fields {
+		switch v := field.Value.(type) {
+		case *dumpNode:
+			v.print(w, indent+"  ", field.Name+": ")
+		case []*dumpNode:
+			for index, node := range v {
+				node.print(w, indent+"  ", fmt.Sprintf("%s[%d]: ", field.Name, index))
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+// printRuntimeError prints the error with the source line of the failing
+// statement and a caret under where it starts, followed by the gosubs and
+// calls that led to it.
+func printRuntimeError(w io.Writer, source []byte, err *lao.RuntimeError) {
+	fmt.Fprintf(w, "%s: %s\n", err.Kind, err)
+	printSourceLine(w, source, err.Line, err.Column)
+
+	for _, frame := range err.Stack {
+		fmt.Fprintf(w, "\tcalled from %s at line %d column %d\n", frame.Name, frame.Line, frame.Column)
+	}
+}
+
+// printParseErrors prints every syntax error with the source line it was
+// found on.
+func printParseErrors(w io.Writer, source []byte, errs lao.ParseErrors) {
+	for _, err := range errs {
+		fmt.Fprintf(w, "syntax error: %s\n", err)
+		printSourceLine(w, source, err.Line, err.Column)
+	}
+}
+
+func printSourceLine(w io.Writer, source []byte, line, column int) {
+	lines := bytes.Split(source, []byte("\n"))
+	if line < 1 || line > len(lines) {
+		return
+	}
+	text := strings.TrimRight(string(lines[line-1]), "\r")
+
+	// Keep the tabs before the caret so it lines up with the source
+	indent := []rune{}
+	for _, r := range text {
+		if len(indent) >= column-1 {
+			break
+		}
+		if r != '\t' {
+			r = ' '
+		}
+		indent = append(indent, r)
+	}
+
+	prefix := fmt.Sprintf("%5d | ", line)
+	fmt.Fprintf(w, "%s%s\n", prefix, text)
+	fmt.Fprintf(w, "%s%s^\n", strings.Repeat(" ", len(prefix)), string(indent))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+// format formats the files named in args, or the standard input when there
+// are none. Like gofmt it prints the result unless -w rewrites the files in
+// place or -d prints a diff instead. It returns the exit status, 1 when a
+// file could not be read, parsed or written.
+func format(args []string, out, errs io.Writer) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	flags.SetOutput(errs)
+	write := flags.Bool("w", false, "write the result to the file instead of the standard output")
+	diff := flags.Bool("d", false, "print a diff instead of the formatted source")
+	flags.Usage = func() {
+		fmt.Fprintln(errs, "usage: lao fmt [-w] [-d] [path ...]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(errs, "cannot use -w with the standard input")
+			return 2
+		}
+
+		source, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(errs, err)
+			return 1
+		}
+		return formatSource("<stdin>", source, *write, *diff, out, errs)
+	}
+
+	status := 0
+	for _, file := range flags.Args() {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(errs, err)
+			status = 1
+			continue
+		}
+
+		if formatSource(file, source, *write, *diff, out, errs) != 0 {
+			status = 1
+		}
+	}
+	return status
+}
+
+func formatSource(name string, source []byte, write, diff bool, out, errs io.Writer) int {
+	statements, err := lao.NewParser(
+		lao.NewTokenizer(bytes.NewReader(source)),
+		lao.WithRecovery(),
+	).Parse()
+	if err != nil {
+		var parseErrors lao.ParseErrors
+		if !errors.As(err, &parseErrors) {
+			fmt.Fprintf(errs, "%s: %s\n", name, err)
+			return 1
+		}
+
+		for _, e := range parseErrors {
+			fmt.Fprintf(errs, "%s:%d:%d: %s\n", name, e.Line, e.Column, e.Err)
+		}
+		return 1
+	}
+
+	formatted := new(bytes.Buffer)
+	if err := lao.Format(formatted, statements); err != nil {
+		fmt.Fprintf(errs, "%s: %s\n", name, err)
+		return 1
+	}
+
+	if diff {
+		printDiff(out, name, source, formatted.Bytes())
+	}
+
+	if write {
+		if bytes.Equal(source, formatted.Bytes()) {
+			return 0
+		}
+
+		info, err := os.Stat(name)
+		if err == nil {
+			err = ioutil.WriteFile(name, formatted.Bytes(), info.Mode().Perm())
+		}
+		if err != nil {
+			fmt.Fprintln(errs, err)
+			return 1
+		}
+		return 0
+	}
+
+	if !diff {
+		out.Write(formatted.Bytes())
+	}
+	return 0
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 
@@ -9,13 +12,50 @@ import (
 )
 
 func main() {
+	// -- ends the commands, so a program named like one of them can run
+	if len(os.Args) >= 2 && os.Args[1] == "--" {
+		run(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) == 2 && os.Args[1] == "repl" {
+		repl(os.Stdin, os.Stdout)
+		return
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "check" {
+		os.Exit(check(os.Args[2:], os.Stdout))
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "fmt" {
+		os.Exit(format(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "debug" {
+		os.Exit(debug(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "tokens" {
+		os.Exit(tokens(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	if len(os.Args) >= 2 && os.Args[1] == "ast" {
+		os.Exit(ast(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	run(os.Args[1:])
+}
+
+// run runs the program in the file given by args, or read from standard
+// input when there is none.
+func run(args []string) {
 	var r io.Reader
 	{
-		if len(os.Args) != 2 {
+		if len(args) != 1 {
 			r = os.Stdin
 		} else {
 
-			filePath := os.Args[1]
+			filePath := args[0]
 			f, err := os.Open(filePath)
 			if err != nil {
 				panic(err)
@@ -26,14 +66,20 @@ func main() {
 		}
 	}
 
+	// Keep the source to show the line of runtime errors
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		panic(err)
+	}
+
 	var tokenizer lao.Tokenizer
 	{
-		tokenizer = lao.NewTokenizer(r)
+		tokenizer = lao.NewTokenizer(bytes.NewReader(source))
 	}
 
 	var parser lao.Parser
 	{
-		parser = lao.NewParser(tokenizer)
+		parser = lao.NewParser(tokenizer, lao.WithRecovery())
 	}
 
 	var interpreter lao.Interpreter
@@ -43,12 +89,20 @@ func main() {
 
 	statements, err := parser.Parse()
 	if err != nil {
-		panic(err)
+		var parseErrors lao.ParseErrors
+		if errors.As(err, &parseErrors) {
+			printParseErrors(os.Stderr, source, parseErrors)
+			os.Exit(1)
+		}
+		log.Fatal(err)
 	}
 	err = interpreter.Execute(statements)
-	if err != nil {
-		if err != io.EOF {
-			log.Fatal(err)
+	if err != nil && err != io.EOF {
+		var runtimeError *lao.RuntimeError
+		if errors.As(err, &runtimeError) {
+			printRuntimeError(os.Stderr, source, runtimeError)
+			os.Exit(1)
 		}
+		log.Fatal(err)
 	}
 }
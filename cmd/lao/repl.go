@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+const replHelp = `Enter Lao statements to run them. Meta-commands:
+  :vars         list the variables defined so far
+  :reset        forget every variable, label and statement
+  :load <file>  run a file inside the session
+  :help         show this message
+  :quit         leave the repl`
+
+// repl reads statements from in one line at a time and runs them on the same
+// interpreter, so variables and labels survive between inputs. Statements
+// spanning several lines, like for loops, are collected until they are
+// complete. Read statements share the same input as the prompt.
+func repl(in io.Reader, out io.Writer) {
+	input := bufio.NewReader(in)
+
+	var interpreter lao.Interpreter
+	{
+		interpreter = lao.NewInterpreter(out, lao.WithInput(input))
+	}
+
+	pending := ""
+	for {
+		if pending == "" {
+			fmt.Fprint(out, "> ")
+		} else {
+			fmt.Fprint(out, "... ")
+		}
+
+		line, err := input.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(out)
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if pending == "" {
+			switch {
+			case line == "":
+				continue
+			case strings.HasPrefix(line, ":"):
+				if quit := replCommand(interpreter, line, out); quit {
+					return
+				}
+				continue
+			}
+		}
+
+		source := pending + line + "\n"
+		statements, err := lao.NewParser(lao.NewTokenizer(strings.NewReader(source))).Parse()
+		if errors.Is(err, lao.ErrUnexpectedEnd) {
+			pending = source
+			continue
+		}
+		pending = ""
+
+		replExecute(interpreter, statements, err, out)
+	}
+}
+
+func replCommand(interpreter lao.Interpreter, line string, out io.Writer) bool {
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case ":quit", ":q":
+		return true
+	case ":help":
+		fmt.Fprintln(out, replHelp)
+	case ":vars":
+		printVariables(interpreter.Variables(), out)
+	case ":reset":
+		interpreter.Reset()
+	case ":load":
+		if len(fields) != 2 {
+			fmt.Fprintln(out, "usage: :load <file>")
+			return false
+		}
+
+		f, err := os.Open(fields[1])
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return false
+		}
+		defer f.Close()
+
+		statements, err := lao.NewParser(lao.NewTokenizer(f)).Parse()
+		replExecute(interpreter, statements, err, out)
+	default:
+		fmt.Fprintf(out, "unknown command %s, try :help\n", fields[0])
+	}
+
+	return false
+}
+
+func replExecute(interpreter lao.Interpreter, statements []lao.Node, err error, out io.Writer) {
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return
+	}
+
+	if err := interpreter.Continue(statements); err != nil && err != io.EOF {
+		fmt.Fprintln(out, err)
+	}
+}
+
+func printVariables(variables map[string]lao.Value, out io.Writer) {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if value := variables[name]; value.Type() == lao.ValueArray {
+			array := value.Array()
+			bounds := make([]string, len(array.Bounds))
+			for i, bound := range array.Bounds {
+				bounds[i] = strconv.Itoa(bound)
+			}
+
+			elements := make([]string, len(array.Elements))
+			for i, element := range array.Elements {
+				elements[i] = formatValue(element)
+			}
+
+			fmt.Fprintf(out, "%s(%s) = [%s]\n", name, strings.Join(bounds, ", "), strings.Join(elements, " "))
+			continue
+		}
+
+		fmt.Fprintf(out, "%s = %s\n", name, formatValue(variables[name]))
+	}
+}
+
+func formatValue(value lao.Value) string {
+	if value.Type() == lao.ValueString {
+		return fmt.Sprintf("%q", value.String())
+	}
+	return value.String()
+}
@@ -3,6 +3,6 @@ module github.com/vectorhacker/lao
 go 1.13
 
 require (
-	github.com/davecgh/go-spew v1.1.1
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/stretchr/testify v1.4.0
 )
@@ -0,0 +1,383 @@
+package lao
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// argumentKind is the kind of value a built-in function accepts
+type argumentKind int
+
+const (
+	argumentNumber argumentKind = iota // integer or real
+	argumentInteger
+	argumentString
+)
+
+func (k argumentKind) String() string {
+	switch k {
+	case argumentInteger:
+		return "an integer"
+	case argumentString:
+		return "a string"
+	}
+	return "a number"
+}
+
+// builtin is a function provided by the interpreter. The last optional
+// arguments may be left out of a call. Returns is 0 when the type of the
+// result depends on the arguments.
+type builtin struct {
+	arguments []argumentKind
+	optional  int
+	returns   VariableType
+	call      func(s *settings, arguments []Value) (Value, error)
+}
+
+var builtins map[string]builtin
+
+func init() {
+	number := []argumentKind{argumentNumber}
+	numbers := []argumentKind{argumentNumber, argumentNumber}
+	text := []argumentKind{argumentString}
+
+	builtins = map[string]builtin{
+		"abs": {arguments: number, call: builtinAbs},
+		"sqrt": {arguments: number, returns: VariableReal, call: realFunction(func(x float64) (float64, error) {
+			if x < 0 {
+				return 0, runtimeErrorf(ErrorArgument, "sqrt of negative number %f", x)
+			}
+			return math.Sqrt(x), nil
+		})},
+		"sin": {arguments: number, returns: VariableReal, call: realFunction(pure(math.Sin))},
+		"cos": {arguments: number, returns: VariableReal, call: realFunction(pure(math.Cos))},
+		"tan": {arguments: number, returns: VariableReal, call: realFunction(pure(math.Tan))},
+		"atn": {arguments: number, returns: VariableReal, call: realFunction(pure(math.Atan))},
+		"log": {arguments: number, returns: VariableReal, call: realFunction(func(x float64) (float64, error) {
+			if x <= 0 {
+				return 0, runtimeErrorf(ErrorArgument, "log of non positive number %f", x)
+			}
+			return math.Log(x), nil
+		})},
+		"exp": {arguments: number, returns: VariableReal, call: realFunction(pure(math.Exp))},
+		"int": {arguments: number, returns: VariableInteger, call: integerFunction("int", math.Floor)},
+		"fix": {arguments: number, returns: VariableInteger, call: integerFunction("fix", math.Trunc)},
+		"sgn": {arguments: number, returns: VariableInteger, call: builtinSgn},
+		"rnd": {arguments: []argumentKind{argumentInteger}, optional: 1, call: builtinRnd},
+		"min": {arguments: numbers, call: builtinMin},
+		"max": {arguments: numbers, call: builtinMax},
+
+		"len":   {arguments: text, returns: VariableInteger, call: builtinLen},
+		"mid":   {arguments: []argumentKind{argumentString, argumentInteger, argumentInteger}, optional: 1, returns: VariableString, call: builtinMid},
+		"left":  {arguments: []argumentKind{argumentString, argumentInteger}, returns: VariableString, call: builtinLeft},
+		"right": {arguments: []argumentKind{argumentString, argumentInteger}, returns: VariableString, call: builtinRight},
+		"instr": {arguments: []argumentKind{argumentString, argumentString}, returns: VariableInteger, call: builtinInstr},
+		"ucase": {arguments: text, returns: VariableString, call: stringFunction(strings.ToUpper)},
+		"lcase": {arguments: text, returns: VariableString, call: stringFunction(strings.ToLower)},
+		"trim":  {arguments: text, returns: VariableString, call: stringFunction(strings.TrimSpace)},
+		"val":   {arguments: text, call: builtinVal},
+		"str":   {arguments: number, returns: VariableString, call: builtinStr},
+		"chr":   {arguments: []argumentKind{argumentInteger}, returns: VariableString, call: builtinChr},
+		"asc":   {arguments: text, returns: VariableInteger, call: builtinAsc},
+	}
+}
+
+// arity describes how many arguments a built-in function takes.
+func (b builtin) arity() string {
+	required := len(b.arguments) - b.optional
+	switch {
+	case b.optional > 0:
+		return fmt.Sprintf("%d to %d arguments", required, len(b.arguments))
+	case required == 1:
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", required)
+}
+
+func (b builtin) accepts(count int) bool {
+	return count <= len(b.arguments) && count >= len(b.arguments)-b.optional
+}
+
+// callBuiltin evaluates the arguments of a call to a built-in function,
+// checks their kinds and runs it.
+func (i *interpreter) callBuiltin(c CallExpression, b builtin) (Value, error) {
+	if err := b.checkCount(c.Name, len(c.Arguments)); err != nil {
+		return Value{}, err
+	}
+
+	arguments := make([]Value, len(c.Arguments))
+	for index, argument := range c.Arguments {
+		value, err := i.evaluateExpression(argument)
+		if err != nil {
+			return Value{}, err
+		}
+
+		if err := b.checkArgument(c.Name, index, value); err != nil {
+			return Value{}, err
+		}
+		arguments[index] = value
+	}
+
+	value, err := b.call(&i.settings, arguments)
+	if err != nil {
+		return Value{}, err
+	}
+	return value, i.checkString(i.ip, value)
+}
+
+// checkCount checks a call to the built-in function named name has a
+// number of arguments it accepts.
+func (b builtin) checkCount(name string, count int) error {
+	if !b.accepts(count) {
+		return runtimeErrorf(ErrorArgument, "%s takes %s but got %d", name, b.arity(), count)
+	}
+	return nil
+}
+
+// checkArgument checks the kind of the value given as the argument at index
+// to the built-in function named name.
+func (b builtin) checkArgument(name string, index int, value Value) error {
+	kind := b.arguments[index]
+	switch value.Type() {
+	case ValueInteger:
+		if kind == argumentNumber || kind == argumentInteger {
+			return nil
+		}
+	case ValueReal:
+		if kind == argumentNumber {
+			return nil
+		}
+	case ValueString:
+		if kind == argumentString {
+			return nil
+		}
+	}
+
+	return runtimeErrorf(
+		ErrorType,
+		"argument %d of %s must be %s but got %s",
+		index+1,
+		name,
+		kind,
+		value.Type(),
+	)
+}
+
+// pure adapts a math function that cannot fail.
+func pure(f func(float64) float64) func(float64) (float64, error) {
+	return func(x float64) (float64, error) {
+		return f(x), nil
+	}
+}
+
+// realFunction makes a built-in function returning a real from one number.
+func realFunction(f func(float64) (float64, error)) func(*settings, []Value) (Value, error) {
+	return func(_ *settings, arguments []Value) (Value, error) {
+		x, err := f(arguments[0].number())
+		if err != nil {
+			return Value{}, err
+		}
+		return RealValue(x), nil
+	}
+}
+
+// integerFunction makes the built-in function named name, rounding one
+// number to an integer. Reals that are not numbers or whose rounding does
+// not fit in an integer are rejected.
+func integerFunction(name string, round func(float64) float64) func(*settings, []Value) (Value, error) {
+	return func(_ *settings, arguments []Value) (Value, error) {
+		if arguments[0].Type() == ValueInteger {
+			return arguments[0], nil
+		}
+
+		x := round(arguments[0].Real())
+		// float64(math.MaxInt64) is 2^63, the first real past the range
+		if math.IsNaN(x) || x < math.MinInt64 || x >= math.MaxInt64 {
+			return Value{}, runtimeErrorf(ErrorArgument, "%s cannot convert %g to an integer", name, arguments[0].Real())
+		}
+		return IntegerValue(int(x)), nil
+	}
+}
+
+func builtinAbs(_ *settings, arguments []Value) (Value, error) {
+	v := arguments[0]
+	if v.Type() == ValueInteger {
+		if v.Integer() < 0 {
+			return IntegerValue(-v.Integer()), nil
+		}
+		return v, nil
+	}
+	return RealValue(math.Abs(v.Real())), nil
+}
+
+func builtinSgn(_ *settings, arguments []Value) (Value, error) {
+	switch x := arguments[0].number(); {
+	case x > 0:
+		return IntegerValue(1), nil
+	case x < 0:
+		return IntegerValue(-1), nil
+	}
+	return IntegerValue(0), nil
+}
+
+// builtinRnd returns a real between 0 and 1 when called without arguments,
+// and an integer from 1 to n when called with n.
+func builtinRnd(s *settings, arguments []Value) (Value, error) {
+	if len(arguments) == 0 {
+		return RealValue(s.random.Float64()), nil
+	}
+
+	n := arguments[0].Integer()
+	if n < 1 {
+		return Value{}, runtimeErrorf(ErrorArgument, "rnd needs a positive integer but got %d", n)
+	}
+
+	return IntegerValue(s.random.Intn(n) + 1), nil
+}
+
+func builtinMin(_ *settings, arguments []Value) (Value, error) {
+	l, r := arguments[0], arguments[1]
+	if l.Type() == ValueInteger && r.Type() == ValueInteger {
+		if r.Integer() < l.Integer() {
+			return r, nil
+		}
+		return l, nil
+	}
+
+	return RealValue(math.Min(l.number(), r.number())), nil
+}
+
+func builtinMax(_ *settings, arguments []Value) (Value, error) {
+	l, r := arguments[0], arguments[1]
+	if l.Type() == ValueInteger && r.Type() == ValueInteger {
+		if r.Integer() > l.Integer() {
+			return r, nil
+		}
+		return l, nil
+	}
+
+	return RealValue(math.Max(l.number(), r.number())), nil
+}
+
+// String functions count characters, not bytes, and positions start at 1.
+
+// stringFunction makes a built-in function transforming one string.
+func stringFunction(f func(string) string) func(*settings, []Value) (Value, error) {
+	return func(_ *settings, arguments []Value) (Value, error) {
+		return StringValue(f(arguments[0].String())), nil
+	}
+}
+
+func builtinLen(_ *settings, arguments []Value) (Value, error) {
+	return IntegerValue(utf8.RuneCountInString(arguments[0].String())), nil
+}
+
+// builtinMid returns the characters of a string from a start position, up
+// to the end or to the given length.
+func builtinMid(_ *settings, arguments []Value) (Value, error) {
+	s := []rune(arguments[0].String())
+
+	start := arguments[1].Integer()
+	if start < 1 {
+		return Value{}, runtimeErrorf(ErrorArgument, "mid start must be 1 or more but got %d", start)
+	}
+	if start > len(s) {
+		return StringValue(""), nil
+	}
+	s = s[start-1:]
+
+	if len(arguments) == 3 {
+		length := arguments[2].Integer()
+		if length < 0 {
+			return Value{}, runtimeErrorf(ErrorArgument, "mid length cannot be negative but got %d", length)
+		}
+		if length < len(s) {
+			s = s[:length]
+		}
+	}
+
+	return StringValue(string(s)), nil
+}
+
+func builtinLeft(_ *settings, arguments []Value) (Value, error) {
+	s := []rune(arguments[0].String())
+
+	n := arguments[1].Integer()
+	if n < 0 {
+		return Value{}, runtimeErrorf(ErrorArgument, "left length cannot be negative but got %d", n)
+	}
+	if n < len(s) {
+		s = s[:n]
+	}
+
+	return StringValue(string(s)), nil
+}
+
+func builtinRight(_ *settings, arguments []Value) (Value, error) {
+	s := []rune(arguments[0].String())
+
+	n := arguments[1].Integer()
+	if n < 0 {
+		return Value{}, runtimeErrorf(ErrorArgument, "right length cannot be negative but got %d", n)
+	}
+	if n < len(s) {
+		s = s[len(s)-n:]
+	}
+
+	return StringValue(string(s)), nil
+}
+
+// builtinInstr returns the position of the first occurrence of the second
+// string in the first, or 0 when it does not occur.
+func builtinInstr(_ *settings, arguments []Value) (Value, error) {
+	s := arguments[0].String()
+
+	index := strings.Index(s, arguments[1].String())
+	if index < 0 {
+		return IntegerValue(0), nil
+	}
+
+	return IntegerValue(utf8.RuneCountInString(s[:index]) + 1), nil
+}
+
+// builtinVal converts a string to an integer, or to a real when it is not a
+// whole number.
+func builtinVal(_ *settings, arguments []Value) (Value, error) {
+	s := strings.TrimSpace(arguments[0].String())
+
+	if v, err := strconv.Atoi(s); err == nil {
+		return IntegerValue(v), nil
+	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return RealValue(v), nil
+	}
+
+	return Value{}, runtimeErrorf(ErrorArgument, "val cannot convert %q to a number", s)
+}
+
+// builtinStr formats a number the same way print does.
+func builtinStr(_ *settings, arguments []Value) (Value, error) {
+	return StringValue(arguments[0].String()), nil
+}
+
+func builtinChr(_ *settings, arguments []Value) (Value, error) {
+	code := arguments[0].Integer()
+	if code < 0 || code > utf8.MaxRune || !utf8.ValidRune(rune(code)) {
+		return Value{}, runtimeErrorf(ErrorArgument, "chr of invalid character code %d", code)
+	}
+
+	return StringValue(string(rune(code))), nil
+}
+
+func builtinAsc(_ *settings, arguments []Value) (Value, error) {
+	s := arguments[0].String()
+	if s == "" {
+		return Value{}, runtimeErrorf(ErrorArgument, "asc of empty string")
+	}
+
+	r, _ := utf8.DecodeRuneInString(s)
+	return IntegerValue(int(r)), nil
+}
@@ -0,0 +1,204 @@
+package lao_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+func TestBuiltins(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		program  string
+		input    string
+		expected string
+		err      string
+	}{
+		{
+			desc:     "abs keeps the type",
+			program:  "print abs(-3)\nprint abs(-2.5)",
+			expected: "3\n2.500000\n",
+		},
+		{
+			desc:     "square root",
+			program:  "print sqrt(16)",
+			expected: "4.000000\n",
+		},
+		{
+			desc:     "trigonometry",
+			program:  "gpi = atn(1) .mul. 4\nprint cos(gpi)\nprint sin(0)\nprint tan(0)",
+			expected: "-1.000000\n0.000000\n0.000000\n",
+		},
+		{
+			desc:     "log and exp",
+			program:  "print log(exp(2))",
+			expected: "2.000000\n",
+		},
+		{
+			desc:     "int rounds down and fix truncates",
+			program:  "print int(-2.5)\nprint fix(-2.5)\nprint int(7)",
+			expected: "-3\n-2\n7\n",
+		},
+		{
+			desc:     "sign",
+			program:  "print sgn(-0.5)\nprint sgn(0)\nprint sgn(9)",
+			expected: "-1\n0\n1\n",
+		},
+		{
+			desc:     "min and max",
+			program:  "print min(3, 1)\nprint max(3, 1.5)",
+			expected: "1\n3.000000\n",
+		},
+		{
+			desc:     "random numbers stay in range",
+			program:  "gx = rnd()\na = rnd(6)\nif gx .ge. 0 .and. gx .lt. 1 .and. a .ge. 1 .and. a .le. 6 then print \"ok\"",
+			expected: "ok\n",
+		},
+		{
+			desc:     "builtin inside an expression",
+			program:  "a = abs(-2) .add. max(1, 4) .mul. 2\nprint a",
+			expected: "10\n",
+		},
+		{
+			desc:    "string argument",
+			program: "print sqrt(\"x\")",
+			err:     "argument 1 of sqrt must be a number but got string at line 1 column 1",
+		},
+		{
+			desc:    "square root of a negative number",
+			program: "print sqrt(-1)",
+			err:     "sqrt of negative number -1.000000 at line 1 column 1",
+		},
+		{
+			desc:    "int of a real past the integers",
+			program: "print int(2.0e63)",
+			err:     "int cannot convert 9.223372036854776e+18 to an integer at line 1 column 1",
+		},
+		{
+			desc:    "fix of infinity",
+			program: "print fix(-exp(1000))",
+			err:     "fix cannot convert -Inf to an integer at line 1 column 1",
+		},
+		{
+			desc:    "int of not a number",
+			program: "gx = exp(1000)\nprint int(gx .sub. gx)",
+			err:     "int cannot convert NaN to an integer at line 2 column 1",
+		},
+		{
+			desc:     "int and fix at the ends of the integers",
+			program:  "print fix(-2.0e63)\nprint int(2.0e62)",
+			expected: "-9223372036854775808\n4611686018427387904\n",
+		},
+		{
+			desc:     "length of utf-8 text",
+			program:  "read w\nprint len(w)\nprint ucase(w)\nprint right(w, 2)",
+			input:    "año\n",
+			expected: "3\nAÑO\nño\n",
+		},
+		{
+			desc:     "substrings",
+			program:  "w = \"hello world\"\nprint mid(w, 7)\nprint mid(w, 2, 3)\nprint left(w, 5)\nprint mid(w, 20)",
+			expected: "world\nell\nhello\n\n",
+		},
+		{
+			desc:     "position of a substring",
+			program:  "read w\nprint instr(w, \"b\")\nprint instr(w, \"z\")",
+			input:    "ñab\n",
+			expected: "3\n0\n",
+		},
+		{
+			desc:     "case and spaces",
+			program:  "print lcase(\"LaO\")\nprint trim(\"  x  \") .add. \"|\"",
+			expected: "lao\nx|\n",
+		},
+		{
+			desc:     "numbers to and from strings",
+			program:  "a = val(\" 42 \") .add. 1\ngx = val(\"2.5\")\nw = str(a) .add. \"/\" .add. str(gx)\nprint w",
+			expected: "43/2.500000\n",
+		},
+		{
+			desc:     "character codes",
+			program:  "print asc(\"A\")\nprint chr(241)\nprint asc(chr(8364))",
+			expected: "65\nñ\n8364\n",
+		},
+		{
+			desc:    "value of text that is not a number",
+			program: "a = val(\"x1\")",
+			err:     `val cannot convert "x1" to a number at line 1 column 1`,
+		},
+		{
+			desc:    "real position",
+			program: "print left(\"abc\", 1.5)",
+			err:     "argument 2 of left must be an integer but got real at line 1 column 1",
+		},
+	}
+	for _, engine := range engines {
+		for _, tC := range testCases {
+			t.Run(engine.name+"/"+tC.desc, func(t *testing.T) {
+				statements, err := lao.NewParser(
+					lao.NewTokenizer(strings.NewReader(tC.program)),
+				).Parse()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				out := new(bytes.Buffer)
+				interpreter := lao.NewInterpreter(
+					out,
+					append(engine.options, lao.WithInput(strings.NewReader(tC.input)), lao.WithRandomSeed(1))...,
+				)
+
+				err = interpreter.Execute(statements)
+				if err == io.EOF {
+					err = nil
+				}
+				if tC.err != "" {
+					assert.EqualError(t, err, tC.err)
+					return
+				}
+
+				assert.NoError(t, err)
+				assert.Equal(t, tC.expected, out.String())
+			})
+		}
+	}
+}
+
+func TestBuiltinParseErrors(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		err   string
+	}{
+		{
+			desc:  "wrong number of arguments",
+			input: "print max(1)",
+			err:   "max takes 2 arguments but got 1 at line 1 column 7",
+		},
+		{
+			desc:  "optional arguments",
+			input: "print rnd(1, 2)",
+			err:   "rnd takes 0 to 1 arguments but got 2 at line 1 column 7",
+		},
+		{
+			desc:  "function named after a builtin",
+			input: "function abs(a)\nreturn a\nend function",
+			err:   "abs is a built-in function and cannot be redeclared at line 1 column 10",
+		},
+		{
+			desc:  "builtin called as a statement",
+			input: "abs(1)",
+			err:   "built-in function abs must be used in an expression at line 1 column 1",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, err := lao.NewParser(lao.NewTokenizer(strings.NewReader(tC.input))).Parse()
+			assert.EqualError(t, err, tC.err)
+		})
+	}
+}
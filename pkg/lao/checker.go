@@ -0,0 +1,564 @@
+package lao
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Severity of a diagnostic
+type Severity int
+
+// Severity
+const (
+	_ Severity = iota
+	SeverityError
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "unknown"
+}
+
+// Diagnostic is a problem found in a program by Check.
+type Diagnostic struct {
+	Severity Severity
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Column, d.Severity, d.Message)
+}
+
+// Check analyses a parsed program without running it. It reports as errors
+// jumps to labels that do not exist, labels defined twice, variables read
+// before any assignment or read can set them and assignments of values that
+// cannot match the type of the variable. Code that can never run, such as
+// statements after end., is reported as a warning. Diagnostics are sorted by
+// position.
+func Check(program []Node) []Diagnostic {
+	c := &checker{
+		labels:     map[string]Token{},
+		procedures: map[string]VariableType{},
+	}
+
+	c.declarations(program)
+
+	main := c.analyse(program, nil, true)
+	for _, statement := range program {
+		var parameters []Variable
+		var body []Node
+		switch s := statement.(type) {
+		case SubStatement:
+			parameters, body = s.Parameters, s.Body
+		case FunctionStatement:
+			parameters, body = s.Parameters, s.Body
+		default:
+			continue
+		}
+
+		// Subs and functions see the globals the program sets anywhere
+		assigned := map[string]bool{}
+		for name := range main.defined {
+			assigned[name] = true
+		}
+		for _, parameter := range parameters {
+			assigned[parameter.Name] = true
+		}
+		c.analyse(body, assigned, false)
+	}
+
+	sort.SliceStable(c.diagnostics, func(a, b int) bool {
+		if c.diagnostics[a].Line != c.diagnostics[b].Line {
+			return c.diagnostics[a].Line < c.diagnostics[b].Line
+		}
+		return c.diagnostics[a].Column < c.diagnostics[b].Column
+	})
+
+	return c.diagnostics
+}
+
+type checker struct {
+	diagnostics []Diagnostic
+	labels      map[string]Token
+	procedures  map[string]VariableType // subs have type 0
+}
+
+func (c *checker) report(severity Severity, node Node, format string, args ...interface{}) {
+	line, column := position(node)
+	c.diagnostics = append(c.diagnostics, Diagnostic{
+		Severity: severity,
+		Line:     line,
+		Column:   column,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// declarations finds the labels, subs and functions of the program, and
+// reports labels defined twice.
+func (c *checker) declarations(program []Node) {
+	for _, statement := range program {
+		switch s := statement.(type) {
+		case LabelStatement:
+			if first, ok := c.labels[s.Name]; ok {
+				c.report(
+					SeverityError,
+					s,
+					"label %s is already defined at line %d column %d",
+					s.Name,
+					first.Line,
+					first.Column,
+				)
+				continue
+			}
+
+			var token Token
+			if tokens := s.Tokens(); len(tokens) > 0 {
+				token = tokens[0]
+			}
+			c.labels[s.Name] = token
+		case SubStatement:
+			c.procedures[s.Name] = 0
+		case FunctionStatement:
+			c.procedures[s.Name] = s.Type
+		}
+	}
+}
+
+// analyse builds the control flow graph of the statements, finds out which
+// variables may have been set before each statement and reports reads of
+// variables that cannot have been, unreachable code and bad assignments.
+// assigned holds the variables set before the first statement. Like the
+// interpreter, jumps only find the labels at the top level of the program.
+func (c *checker) analyse(statements []Node, assigned map[string]bool, program bool) *graph {
+	g := &graph{
+		checker:  c,
+		ids:      map[*Node]int{},
+		labels:   map[string]int{},
+		defined:  map[string]bool{},
+		jumps:    map[int]string{},
+		returned: map[int]bool{},
+	}
+
+	entry := g.build(statements, -1)
+	if program {
+		for index, statement := range statements {
+			if label, ok := statement.(LabelStatement); ok {
+				g.labels[label.Name] = g.ids[&statements[index]]
+			}
+		}
+	}
+	g.link()
+
+	in := g.flow(entry, assigned)
+
+	for id, n := range g.nodes {
+		c.checkTypes(n.statement)
+
+		if in[id] == nil {
+			continue
+		}
+		for _, variable := range n.uses {
+			if !in[id][variable.Name] {
+				c.report(SeverityError, variable, "variable %s is read before it is assigned", variable.Name)
+			}
+		}
+	}
+
+	g.unreachable(statements, in)
+
+	return g
+}
+
+// flowNode is a statement, or the condition of an elseif, in the control
+// flow graph.
+type flowNode struct {
+	statement Node
+	uses      []Variable
+	defines   string
+	next      []int
+}
+
+type graph struct {
+	checker  *checker
+	nodes    []*flowNode
+	ids      map[*Node]int   // node of each statement
+	labels   map[string]int  // node of each label of the top level
+	defined  map[string]bool // variables set anywhere
+	jumps    map[int]string  // goto and gosub nodes with their label
+	returned map[int]bool    // return statements
+	loops    []int           // nodes after the loops around the statement
+}
+
+func (g *graph) node(statement Node, uses []Variable, defines string, next ...int) int {
+	if defines != "" {
+		g.defined[defines] = true
+	}
+
+	g.nodes = append(g.nodes, &flowNode{
+		statement: statement,
+		uses:      uses,
+		defines:   defines,
+		next:      next,
+	})
+	return len(g.nodes) - 1
+}
+
+// build adds the statements to the graph so that the last one goes on to
+// follow, and returns the node of the first one. A follow of -1 is the end
+// of the program.
+func (g *graph) build(statements []Node, follow int) int {
+	entry := follow
+	for index := len(statements) - 1; index >= 0; index-- {
+		entry = g.add(&statements[index], entry)
+		g.ids[&statements[index]] = entry
+	}
+	return entry
+}
+
+func (g *graph) add(statement *Node, follow int) int {
+	c := g.checker
+
+	switch s := (*statement).(type) {
+	case AssignmentStatement:
+		uses := c.reads(s.ArithmeticExpression, nil)
+		return g.node(s, c.target(s.Variable, uses), g.defines(s.Variable), follow)
+	case ReadStatement:
+		return g.node(s, c.target(s.Variable, nil), g.defines(s.Variable), follow)
+	case DimStatement:
+		return g.node(s, c.reads(nil, nil, s.Bounds...), s.Variable.Name, follow)
+	case PrintStatement:
+		return g.node(s, c.reads(s.Argumenent, nil), "", follow)
+	case CallStatement:
+		return g.node(s, c.reads(nil, nil, s.Arguments...), "", follow)
+	case ReturnStatement:
+		id := g.node(s, c.reads(s.Value, nil), "")
+		g.returned[id] = true
+		return id
+	case EndStatement:
+		return g.node(s, nil, "")
+	case GotoStatement:
+		id := g.node(s, nil, "")
+		g.jumps[id] = s.Label
+		return id
+	case GosubStatement:
+		id := g.node(s, nil, "", follow)
+		g.jumps[id] = s.Label
+		return id
+	case ExitStatement:
+		if len(g.loops) == 0 {
+			c.report(SeverityError, s, "exit outside of a loop")
+			return g.node(s, nil, "")
+		}
+		return g.node(s, nil, "", g.loops[len(g.loops)-1])
+	case IfStatement:
+		otherwise := g.build(s.Else, follow)
+		for index := len(s.ElseIfs) - 1; index >= 0; index-- {
+			clause := s.ElseIfs[index]
+			body := g.build(clause.Body, follow)
+			otherwise = g.node(clause, c.reads(clause.Condition, nil), "", body, otherwise)
+		}
+		then := g.build(s.Then, follow)
+		return g.node(s, c.reads(s.Condition, nil), "", then, otherwise)
+	case ForStatement:
+		id := g.node(s, c.reads(nil, nil, s.From, s.To, s.Step), s.Variable.Name)
+		body := g.loop(s.Body, id, follow)
+		g.nodes[id].next = []int{body, follow}
+		return id
+	case WhileStatement:
+		id := g.node(s, c.reads(s.Condition, nil), "")
+		body := g.loop(s.Body, id, follow)
+		g.nodes[id].next = []int{body, follow}
+		return id
+	case DoStatement:
+		until := g.node(s, c.reads(s.Condition, nil), "")
+		body := g.loop(s.Body, until, follow)
+		g.nodes[until].next = []int{body, follow}
+		return body
+	}
+
+	// Rem statements, labels and declarations of subs and functions do
+	// nothing
+	return g.node(*statement, nil, "", follow)
+}
+
+// loop builds the body of a loop that goes back to again after each run,
+// with exit statements going to follow.
+func (g *graph) loop(body []Node, again, follow int) int {
+	g.loops = append(g.loops, follow)
+	defer func() { g.loops = g.loops[:len(g.loops)-1] }()
+
+	return g.build(body, again)
+}
+
+// defines returns the variable an assignment or read sets. Setting an array
+// element does not define a variable.
+func (g *graph) defines(variable Variable) string {
+	if variable.Indexes != nil {
+		return ""
+	}
+	return variable.Name
+}
+
+// link connects jumps to their labels and returns to the statements after
+// each gosub.
+func (g *graph) link() {
+	var after []int
+	for id, label := range g.jumps {
+		target, ok := g.labels[label]
+		if !ok {
+			g.checker.report(SeverityError, g.nodes[id].statement, "label %s is not defined", label)
+			continue
+		}
+
+		if _, gosub := g.nodes[id].statement.(GosubStatement); gosub {
+			after = append(after, g.nodes[id].next...)
+		}
+		g.nodes[id].next = append([]int{target}, g.nodes[id].next...)
+	}
+
+	for id := range g.returned {
+		g.nodes[id].next = append(g.nodes[id].next, after...)
+	}
+}
+
+// flow returns the variables that may have been set before each node. Nodes
+// that cannot be reached have none.
+func (g *graph) flow(entry int, assigned map[string]bool) []map[string]bool {
+	in := make([]map[string]bool, len(g.nodes))
+	if entry < 0 {
+		return in
+	}
+
+	in[entry] = map[string]bool{}
+	for name := range assigned {
+		in[entry][name] = true
+	}
+
+	work := []int{entry}
+	for len(work) > 0 {
+		id := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		out := in[id]
+		if d := g.nodes[id].defines; d != "" && !out[d] {
+			out = copySet(out)
+			out[d] = true
+		}
+
+		for _, next := range g.nodes[id].next {
+			if next < 0 {
+				continue
+			}
+
+			if in[next] == nil {
+				in[next] = copySet(out)
+				work = append(work, next)
+				continue
+			}
+
+			changed := false
+			for name := range out {
+				if !in[next][name] {
+					in[next][name] = true
+					changed = true
+				}
+			}
+			if changed {
+				work = append(work, next)
+			}
+		}
+	}
+
+	return in
+}
+
+func copySet(set map[string]bool) map[string]bool {
+	copied := make(map[string]bool, len(set)+1)
+	for name := range set {
+		copied[name] = true
+	}
+	return copied
+}
+
+// unreachable warns about the first statement of every run of statements
+// that can never run. Blocks of reachable statements are checked too.
+func (g *graph) unreachable(statements []Node, in []map[string]bool) {
+	previous := true
+	for index := range statements {
+		statement := statements[index]
+		switch statement.(type) {
+		case RemStatement, SubStatement, FunctionStatement:
+			continue
+		}
+
+		reachable := in[g.ids[&statements[index]]] != nil
+		if !reachable && previous {
+			g.checker.report(SeverityWarning, statement, "unreachable code")
+		}
+		previous = reachable
+
+		if !reachable {
+			continue
+		}
+
+		switch s := statement.(type) {
+		case IfStatement:
+			g.unreachable(s.Then, in)
+			for _, clause := range s.ElseIfs {
+				g.unreachable(clause.Body, in)
+			}
+			g.unreachable(s.Else, in)
+		case ForStatement:
+			g.unreachable(s.Body, in)
+		case WhileStatement:
+			g.unreachable(s.Body, in)
+		case DoStatement:
+			g.unreachable(s.Body, in)
+		}
+	}
+}
+
+// target returns the variables read to set the variable: the array and its
+// indexes for an element.
+func (c *checker) target(variable Variable, uses []Variable) []Variable {
+	if variable.Indexes == nil {
+		return uses
+	}
+	return c.reads(variable, uses)
+}
+
+// reads appends the variables read by the expressions to uses, and
+// reports calls without a name or with the wrong number of arguments for a
+// built-in function.
+func (c *checker) reads(expression Node, uses []Variable, more ...Node) []Variable {
+	for _, e := range append([]Node{expression}, more...) {
+		switch e := e.(type) {
+		case Variable:
+			uses = append(uses, e)
+			uses = c.reads(nil, uses, e.Indexes...)
+		case ArithmeticExpression:
+			uses = c.reads(e.Left, uses, e.Right)
+		case ConditionalExpression:
+			uses = c.reads(e.Left, uses, e.Right)
+		case ParenthesizedExpression:
+			uses = c.reads(e.Expression, uses)
+		case CallExpression:
+			_, procedure := c.procedures[e.Name]
+			b, builtin := builtins[e.Name]
+			switch {
+			case e.Name == "":
+				c.report(SeverityError, e, "call without a name")
+			case procedure:
+			case builtin:
+				if !b.accepts(len(e.Arguments)) {
+					c.report(SeverityError, e, "%s takes %s but got %d", e.Name, b.arity(), len(e.Arguments))
+				}
+			default:
+				// An array declared in an earlier input
+				uses = append(uses, Variable{Name: e.Name, tokens: e.tokens})
+			}
+			uses = c.reads(nil, uses, e.Arguments...)
+		}
+	}
+	return uses
+}
+
+// checkTypes reports assignments of values that cannot match the type of
+// the variable.
+func (c *checker) checkTypes(statement Node) {
+	assignment, ok := statement.(AssignmentStatement)
+	if !ok {
+		return
+	}
+
+	variable := assignment.Variable
+	switch t := c.typeOf(assignment.ArithmeticExpression); {
+	case t == typeCondition:
+		c.report(SeverityError, assignment, "cannot assign a condition to %s variable %s", variable.Type, variable.Name)
+	case t != 0 && t != variable.Type:
+		c.report(SeverityError, assignment, "cannot assign %s to %s variable %s", t, variable.Type, variable.Name)
+	}
+}
+
+// typeCondition is the type of relational and logical expressions, which
+// no variable can hold.
+const typeCondition VariableType = -1
+
+// typeOf returns the type an expression evaluates to, or 0 when it can only
+// be known at run time.
+func (c *checker) typeOf(expression Node) VariableType {
+	switch e := expression.(type) {
+	case IntegerNumber:
+		return VariableInteger
+	case RealNumber:
+		return VariableReal
+	case String:
+		return VariableString
+	case Variable:
+		return e.Type
+	case ParenthesizedExpression:
+		return c.typeOf(e.Expression)
+	case ConditionalExpression:
+		return typeCondition
+	case ArithmeticExpression:
+		if e.Left == nil {
+			return c.typeOf(e.Right)
+		}
+
+		left, right := c.typeOf(e.Left), c.typeOf(e.Right)
+		switch {
+		case left <= 0 || right <= 0:
+			return 0
+		case left == VariableString || right == VariableString:
+			if e.Operator == ArithmeticAdd {
+				return VariableString
+			}
+			return 0
+		case left == VariableInteger && right == VariableInteger:
+			return VariableInteger
+		}
+		return VariableReal
+	case CallExpression:
+		if t, ok := c.procedures[e.Name]; ok {
+			return t
+		}
+
+		b, ok := builtins[e.Name]
+		if !ok {
+			return implicitType(e.Name)
+		}
+		if b.returns != 0 {
+			return b.returns
+		}
+		// reads reports the calls with the wrong number of arguments
+		if !b.accepts(len(e.Arguments)) {
+			return 0
+		}
+
+		switch e.Name {
+		case "abs":
+			return c.typeOf(e.Arguments[0])
+		case "min", "max":
+			return c.typeOf(ArithmeticExpression{
+				Left:     e.Arguments[0],
+				Right:    e.Arguments[1],
+				Operator: ArithmeticAdd,
+			})
+		case "rnd":
+			if len(e.Arguments) == 0 {
+				return VariableReal
+			}
+			return VariableInteger
+		}
+	}
+
+	return 0
+}
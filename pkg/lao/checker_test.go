@@ -0,0 +1,165 @@
+package lao_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		program     string
+		diagnostics []string
+	}{
+		{
+			desc:    "valid program",
+			program: "read a\nb = a .add. 1\nif b .gt. 2 then goto done\nprint b\ndone:\nend.",
+		},
+		{
+			desc:    "goto without label",
+			program: "a = 1\ngoto nowhere\nprint a",
+			diagnostics: []string{
+				"2:1: error: label nowhere is not defined",
+				"3:1: warning: unreachable code",
+			},
+		},
+		{
+			desc:        "duplicate label",
+			program:     "top:\nprint 1\ntop:\nend.",
+			diagnostics: []string{"3:1: error: label top is already defined at line 1 column 1"},
+		},
+		{
+			desc:        "read before assignment",
+			program:     "print a\na = 1\nprint a",
+			diagnostics: []string{"1:7: error: variable a is read before it is assigned"},
+		},
+		{
+			desc:    "assigned on a path through a loop",
+			program: "c = 0\nagain:\nif c .gt. 0 then print b\nb = c\nc = c .add. 1\nif c .lt. 3 then goto again",
+		},
+		{
+			desc:    "assigned in a gosub",
+			program: "gosub setup\nprint a\nend.\nsetup:\na = 1\nreturn",
+		},
+		{
+			desc:    "counters, arrays and parameters",
+			program: "dim b(3)\nfor c = 0 to 3\nb(c) = c\nnext c\nsub show(d)\nprint d\nprint c\nend sub\nshow(b(1))",
+		},
+		{
+			desc:        "local variables are not globals",
+			program:     "sub setup()\nz = \"x\"\nend sub\nsetup()\nprint z",
+			diagnostics: []string{"5:7: error: variable z is read before it is assigned"},
+		},
+		{
+			desc:    "mismatched assignments",
+			program: "a = 1.5\ngx = 2\nw = \"a\" .add. 1\nb = 1 .gt. 0\ngy = sqrt(2)\nc = int(gy)\nd = len(w) .mul. abs(-2)",
+			diagnostics: []string{
+				"1:1: error: cannot assign real to integer variable a",
+				"2:1: error: cannot assign integer to real variable gx",
+				"4:1: error: cannot assign a condition to integer variable b",
+			},
+		},
+		{
+			desc:        "code after end",
+			program:     "print 1\nend.\nrem nothing\nprint 2\nprint 3\nlater:\nprint 4",
+			diagnostics: []string{"4:1: warning: unreachable code"},
+		},
+		{
+			desc:        "code after exit inside a loop",
+			program:     "a = 1\nwhile a .lt. 3\na = a .add. 1\nexit\nprint a\nwend",
+			diagnostics: []string{"5:1: warning: unreachable code"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			statements, err := lao.NewParser(
+				lao.NewTokenizer(strings.NewReader(tC.program)),
+			).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diagnostics := []string{}
+			for _, d := range lao.Check(statements) {
+				diagnostics = append(diagnostics, d.String())
+			}
+			if tC.diagnostics == nil {
+				tC.diagnostics = []string{}
+			}
+			assert.Equal(t, tC.diagnostics, diagnostics)
+		})
+	}
+}
+
+func TestCheckUnmarshalled(t *testing.T) {
+	// The parser rejects these programs, but an unmarshalled one may have them
+	testCases := []struct {
+		desc        string
+		data        string
+		diagnostics []string
+	}{
+		{
+			desc: "label inside a loop",
+			data: `[{"type": "WhileStatement",
+				"condition": {"type": "ConditionalExpression", "operator": ".eq.",
+					"left": {"type": "IntegerNumber", "value": "1"},
+					"right": {"type": "IntegerNumber", "value": "1"}},
+				"body": [
+					{"type": "GotoStatement", "label": "skip",
+						"tokens": [{"kind": "keyword", "value": "goto", "line": 2, "column": 1}]},
+					{"type": "LabelStatement", "name": "skip",
+						"tokens": [{"kind": "label", "value": "skip:", "line": 3, "column": 1}]}
+				]}]`,
+			diagnostics: []string{
+				"2:1: error: label skip is not defined",
+				"3:1: warning: unreachable code",
+			},
+		},
+		{
+			desc:        "exit outside of a loop",
+			data:        `[{"type": "ExitStatement", "tokens": [{"kind": "keyword", "value": "exit", "line": 1, "column": 1}]}]`,
+			diagnostics: []string{"1:1: error: exit outside of a loop"},
+		},
+		{
+			desc: "built-in with too few arguments",
+			data: `[{"type": "AssignmentStatement",
+				"variable": {"type": "Variable", "name": "a", "variableType": "integer"},
+				"arithmeticExpression": {"type": "CallExpression", "name": "max",
+					"arguments": [{"type": "IntegerNumber", "value": "1"}],
+					"tokens": [{"kind": "identifier", "value": "max", "line": 1, "column": 5}]}}]`,
+			diagnostics: []string{"1:5: error: max takes 2 arguments but got 1"},
+		},
+		{
+			desc: "call without a name",
+			data: `[{"type": "PrintStatement",
+				"argument": {"type": "CallExpression", "name": "",
+					"tokens": [{"kind": "identifier", "value": "", "line": 1, "column": 7}]}}]`,
+			diagnostics: []string{"1:7: error: call without a name"},
+		},
+		{
+			desc: "abs without arguments",
+			data: `[{"type": "AssignmentStatement",
+				"variable": {"type": "Variable", "name": "a", "variableType": "integer"},
+				"arithmeticExpression": {"type": "CallExpression", "name": "abs",
+					"tokens": [{"kind": "identifier", "value": "abs", "line": 1, "column": 5}]}}]`,
+			diagnostics: []string{"1:5: error: abs takes 1 argument but got 0"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			statements, err := lao.UnmarshalProgram([]byte(tC.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diagnostics := []string{}
+			for _, d := range lao.Check(statements) {
+				diagnostics = append(diagnostics, d.String())
+			}
+			assert.Equal(t, tC.diagnostics, diagnostics)
+		})
+	}
+}
@@ -0,0 +1,587 @@
+package lao
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// opcode is the operation of a virtual machine instruction
+type opcode byte
+
+// Instructions take their values from the top of the stack and push their
+// results back on it. Variables are addressed by slot: a slot of zero or
+// more is a global, and a negative slot s is the local -s-1 of the running
+// sub or function.
+const (
+	opStep         opcode = iota // count a step of the program, the test of a loop when a is 1
+	opLiteral                    // push literal a
+	opLoad                       // push variable a, checked as load mode b
+	opDefined                    // stop unless variable a is defined
+	opElement                    // pop b indexes and push the element of array a
+	opNegate                     // negate the top of the stack
+	opNot                        // replace the boolean on top of the stack by its opposite
+	opArithmetic                 // pop two values and push their result for operator a
+	opCompare                    // pop two values and push their result for operator a
+	opBuiltin                    // pop b arguments and push the result of built-in constant a
+	opCall                       // pop b arguments and call the procedure of call site constant a
+	opResult                     // pop the value the running function returns
+	opReturn                     // return from the innermost gosub, sub or function
+	opAssignable                 // stop unless the top of the stack fits variable constant a
+	opStore                      // pop a value into variable a
+	opStoreElement               // pop b indexes and a value into an element of array a
+	opDim                        // pop b bounds and declare the array of dimension constant a
+	opRead                       // push a line of input read for variable constant a
+	opPrint                      // pop a value and print it
+	opJump                       // continue at address a
+	opJumpUnless                 // pop a condition and continue at address a when it is false
+	opGoto                       // continue at address a of the label named by constant b
+	opGosub                      // call address a of the label of gosub constant b
+	opForPrepare                 // pop the bounds of for loop constant a and start counting
+	opForTest                    // continue at address b when for loop constant a is finished
+	opForNext                    // step for loop constant a and continue at address b
+	opEnd                        // stop the program
+	opFail                       // stop with error constant a
+)
+
+// loadMode is how opLoad reports a variable that is not defined.
+type loadMode int
+
+const (
+	loadValue loadMode = iota // in an expression
+	loadPrint                 // as the argument of print
+)
+
+// instruction is one step of the virtual machine. Statement is the index of
+// the statement it was compiled from, for the position of errors.
+type instruction struct {
+	op        opcode
+	a, b      int
+	statement int
+}
+
+// routine is a sub or function compiled to bytecode.
+type routine struct {
+	procedure
+	entry     int
+	arguments []int // local slot of each parameter
+	slots     map[string]int
+	names     []string
+	global    []int // global slot each local falls back to, or -1
+}
+
+// local returns the slot of a local variable, adding it when it is new.
+func (r *routine) local(name string, global int) int {
+	if slot, ok := r.slots[name]; ok && name != "" {
+		return slot
+	}
+
+	slot := len(r.names)
+	if name != "" {
+		r.slots[name] = slot
+	}
+	r.names = append(r.names, name)
+	r.global = append(r.global, global)
+	return slot
+}
+
+// callSite is a call to a sub or function, or to an array declared in an
+// earlier input that parsed as a call.
+type callSite struct {
+	node      Node
+	name      string
+	procedure int
+	variable  int
+	value     bool // the result is used by an expression
+}
+
+// builtinCall is a call to a built-in function.
+type builtinCall struct {
+	name string
+	builtin
+}
+
+// dimension is an array declared by a dim statement.
+type dimension struct {
+	variable Variable
+	slot     int
+}
+
+// forLoop is the counter of a for statement and the slots keeping its
+// bound and step.
+type forLoop struct {
+	counter  Variable
+	variable int
+	to       int
+	step     int
+}
+
+// compiler turns statements into bytecode. It keeps the slots of the
+// globals, labels and procedures it has seen, so statements compiled later
+// refer to the same ones.
+type compiler struct {
+	code       []instruction
+	constants  []interface{}
+	literals   []Value
+	statements []Node
+
+	values     map[Value]int // index of each literal
+	globals    map[string]int
+	names      []string // name of each global slot, empty for hidden ones
+	labels     map[string]int
+	gotos      map[string][]int // gotos and gosubs of each label
+	procedures map[string]int
+	routines   []*routine
+
+	scope     *routine // procedure being compiled, nil at the top level
+	exits     [][]int  // exits of the loops being compiled
+	statement int
+}
+
+func newCompiler() compiler {
+	return compiler{
+		values:     map[Value]int{},
+		globals:    map[string]int{},
+		labels:     map[string]int{},
+		gotos:      map[string][]int{},
+		procedures: map[string]int{},
+	}
+}
+
+// compile appends the bytecode of statements and returns the address it
+// starts at. Labels and procedures declared in the statements replace the
+// ones with the same names.
+func (c *compiler) compile(statements []Node) int {
+	start := len(c.code)
+	for _, statement := range statements {
+		switch s := statement.(type) {
+		case LabelStatement:
+			c.begin(s)
+			c.label(s.Name)
+		case SubStatement, FunctionStatement:
+			c.begin(s)
+			c.procedure(declaration(s))
+		default:
+			c.compileStatement(statement)
+		}
+	}
+	return start
+}
+
+// label places a label at the next instruction and points the gotos and
+// gosubs already compiled for it there.
+func (c *compiler) label(name string) {
+	address := len(c.code)
+	c.labels[name] = address
+	for _, jump := range c.gotos[name] {
+		c.code[jump].a = address
+	}
+}
+
+// procedure compiles the body of a sub or function out of the way of the
+// statements around it.
+func (c *compiler) procedure(proc procedure) {
+	skip := c.emit(opJump, -1, 0)
+
+	r := &routine{
+		procedure: proc,
+		entry:     len(c.code),
+		slots:     map[string]int{},
+	}
+	for _, parameter := range proc.parameters {
+		r.arguments = append(r.arguments, r.local(parameter.Name, c.global(parameter.Name)))
+	}
+	c.declareLocals(r, proc.body)
+
+	c.scope = r
+	c.block(proc.body)
+	c.emit(opReturn, 0, 0)
+	c.scope = nil
+
+	c.code[skip].a = len(c.code)
+
+	slot := c.procedureSlot(proc.name)
+	c.routines[slot] = r
+}
+
+// declareLocals gives a local slot to every variable the statements assign,
+// as assignments inside subs and functions never change globals.
+func (c *compiler) declareLocals(r *routine, statements []Node) {
+	for _, statement := range statements {
+		switch s := statement.(type) {
+		case AssignmentStatement:
+			if s.Variable.Indexes == nil {
+				r.local(s.Variable.Name, c.global(s.Variable.Name))
+			}
+		case ReadStatement:
+			if s.Variable.Indexes == nil {
+				r.local(s.Variable.Name, c.global(s.Variable.Name))
+			}
+		case DimStatement:
+			r.local(s.Variable.Name, c.global(s.Variable.Name))
+		case ForStatement:
+			r.local(s.Variable.Name, c.global(s.Variable.Name))
+			c.declareLocals(r, s.Body)
+		case IfStatement:
+			c.declareLocals(r, s.Then)
+			for _, clause := range s.ElseIfs {
+				c.declareLocals(r, clause.Body)
+			}
+			c.declareLocals(r, s.Else)
+		case WhileStatement:
+			c.declareLocals(r, s.Body)
+		case DoStatement:
+			c.declareLocals(r, s.Body)
+		}
+	}
+}
+
+// global returns the slot of a global variable, adding it when it is new.
+func (c *compiler) global(name string) int {
+	if slot, ok := c.globals[name]; ok {
+		return slot
+	}
+
+	slot := len(c.names)
+	c.globals[name] = slot
+	c.names = append(c.names, name)
+	return slot
+}
+
+// variable returns the slot of a variable in the scope being compiled.
+func (c *compiler) variable(name string) int {
+	if c.scope != nil {
+		if slot, ok := c.scope.slots[name]; ok {
+			return -slot - 1
+		}
+	}
+	return c.global(name)
+}
+
+// hidden returns a new slot, in the scope being compiled, for a value the
+// program cannot name.
+func (c *compiler) hidden() int {
+	if c.scope != nil {
+		return -c.scope.local("", -1) - 1
+	}
+
+	slot := len(c.names)
+	c.names = append(c.names, "")
+	return slot
+}
+
+func (c *compiler) procedureSlot(name string) int {
+	if slot, ok := c.procedures[name]; ok {
+		return slot
+	}
+
+	slot := len(c.routines)
+	c.procedures[name] = slot
+	c.routines = append(c.routines, nil)
+	return slot
+}
+
+// constant returns the index of a value an instruction refers to.
+func (c *compiler) constant(value interface{}) int {
+	c.constants = append(c.constants, value)
+	return len(c.constants) - 1
+}
+
+// literal returns the index of a literal value, sharing equal ones.
+func (c *compiler) literal(value Value) int {
+	if index, ok := c.values[value]; ok {
+		return index
+	}
+
+	c.values[value] = len(c.literals)
+	c.literals = append(c.literals, value)
+	return len(c.literals) - 1
+}
+
+// emit appends an instruction for the statement being compiled and returns
+// its address.
+func (c *compiler) emit(op opcode, a, b int) int {
+	c.code = append(c.code, instruction{op: op, a: a, b: b, statement: c.statement})
+	return len(c.code) - 1
+}
+
+func (c *compiler) block(statements []Node) {
+	for _, statement := range statements {
+		c.compileStatement(statement)
+	}
+}
+
+// loop compiles the body of a loop and returns the exits found in it.
+func (c *compiler) loop(body []Node) []int {
+	c.exits = append(c.exits, nil)
+	c.block(body)
+
+	exits := c.exits[len(c.exits)-1]
+	c.exits = c.exits[:len(c.exits)-1]
+	return exits
+}
+
+// patch points jumps at the next instruction.
+func (c *compiler) patch(jumps ...int) {
+	for _, jump := range jumps {
+		c.code[jump].a = len(c.code)
+	}
+}
+
+// begin starts the code of a statement with the step it counts for.
+func (c *compiler) begin(statement Node) {
+	c.statement = len(c.statements)
+	c.statements = append(c.statements, statement)
+	c.emit(opStep, 0, 0)
+}
+
+func (c *compiler) compileStatement(statement Node) {
+	outer := c.statement
+	c.begin(statement)
+	defer func() { c.statement = outer }()
+
+	switch s := statement.(type) {
+	case AssignmentStatement:
+		c.expression(s.ArithmeticExpression)
+		c.emit(opAssignable, c.constant(s.Variable), 0)
+		c.store(s.Variable)
+	case IfStatement:
+		c.compileIf(s)
+	case PrintStatement:
+		c.print(s)
+	case ReadStatement:
+		c.emit(opRead, c.constant(s.Variable), 0)
+		c.store(s.Variable)
+	case EndStatement:
+		c.emit(opEnd, 0, 0)
+	case GotoStatement:
+		c.jumpTo(opGoto, s.Label, c.constant(s.Label))
+	case GosubStatement:
+		c.jumpTo(opGosub, s.Label, c.constant(s))
+	case ReturnStatement:
+		if s.Value != nil {
+			c.expression(s.Value)
+			c.emit(opResult, 0, 0)
+		}
+		c.emit(opReturn, 0, 0)
+	case CallStatement:
+		c.call(s, s.Name, s.Arguments, false)
+	case ForStatement:
+		c.compileFor(s)
+	case WhileStatement:
+		start := c.emit(opStep, 1, 0)
+		c.expression(s.Condition)
+		end := c.emit(opJumpUnless, -1, 0)
+		exits := c.loop(s.Body)
+		c.emit(opJump, start, 0)
+		c.patch(append(exits, end)...)
+	case DoStatement:
+		start := len(c.code)
+		exits := c.loop(s.Body)
+		c.emit(opStep, 1, 0)
+		c.expression(s.Condition)
+		c.emit(opJumpUnless, start, 0)
+		c.patch(exits...)
+	case ExitStatement:
+		n := len(c.exits)
+		if n == 0 {
+			c.emit(opFail, c.constant(errExit), 0)
+			break
+		}
+		c.exits[n-1] = append(c.exits[n-1], c.emit(opJump, -1, 0))
+	case DimStatement:
+		for _, bound := range s.Bounds {
+			c.expression(bound)
+		}
+		d := &dimension{variable: s.Variable, slot: c.variable(s.Variable.Name)}
+		c.emit(opDim, c.constant(d), len(s.Bounds))
+	}
+}
+
+// jumpTo compiles a goto or gosub, pointing it at its label when it is
+// already known.
+func (c *compiler) jumpTo(op opcode, label string, constant int) {
+	address, ok := c.labels[label]
+	if !ok {
+		address = -1
+	}
+
+	jump := c.emit(op, address, constant)
+	c.gotos[label] = append(c.gotos[label], jump)
+}
+
+func (c *compiler) compileIf(s IfStatement) {
+	var ends []int
+
+	c.expression(s.Condition)
+	next := c.emit(opJumpUnless, -1, 0)
+	c.block(s.Then)
+	ends = append(ends, c.emit(opJump, -1, 0))
+
+	for _, clause := range s.ElseIfs {
+		c.patch(next)
+		c.expression(clause.Condition)
+		next = c.emit(opJumpUnless, -1, 0)
+		c.block(clause.Body)
+		ends = append(ends, c.emit(opJump, -1, 0))
+	}
+
+	c.patch(next)
+	c.block(s.Else)
+	c.patch(ends...)
+}
+
+func (c *compiler) compileFor(s ForStatement) {
+	c.expression(s.From)
+	c.expression(s.To)
+	if s.Step != nil {
+		c.expression(s.Step)
+	} else {
+		c.emit(opLiteral, c.literal(IntegerValue(1)), 0)
+	}
+
+	loop := c.constant(&forLoop{
+		counter:  s.Variable,
+		variable: c.variable(s.Variable.Name),
+		to:       c.hidden(),
+		step:     c.hidden(),
+	})
+
+	c.emit(opForPrepare, loop, 0)
+	next := c.emit(opStep, 1, 0)
+	test := c.emit(opForTest, loop, -1)
+	exits := c.loop(s.Body)
+	c.emit(opForNext, loop, next)
+
+	c.code[test].b = len(c.code)
+	c.patch(exits...)
+}
+
+// print compiles a print statement. Number literals print as they are
+// written.
+func (c *compiler) print(s PrintStatement) {
+	switch a := s.Argumenent.(type) {
+	case Variable:
+		slot := c.variable(a.Name)
+		if a.Indexes != nil {
+			c.emit(opDefined, slot, 0)
+			c.element(slot, a.Indexes)
+		} else {
+			c.emit(opLoad, slot, int(loadPrint))
+		}
+	case String:
+		c.emit(opLiteral, c.literal(StringValue(strings.ReplaceAll(a.Value, "\"", ""))), 0)
+	case IntegerNumber:
+		c.emit(opLiteral, c.literal(StringValue(a.Value)), 0)
+	case RealNumber:
+		c.emit(opLiteral, c.literal(StringValue(a.Value)), 0)
+	case nil:
+		c.emit(opLiteral, c.literal(StringValue("")), 0)
+	default:
+		c.expression(a)
+	}
+	c.emit(opPrint, 0, 0)
+}
+
+// store pops a value into a variable or an array element.
+func (c *compiler) store(v Variable) {
+	slot := c.variable(v.Name)
+	if v.Indexes == nil {
+		c.emit(opStore, slot, 0)
+		return
+	}
+
+	for _, index := range v.Indexes {
+		c.expression(index)
+	}
+	c.emit(opStoreElement, slot, len(v.Indexes))
+}
+
+func (c *compiler) element(slot int, indexes []Node) {
+	for _, index := range indexes {
+		c.expression(index)
+	}
+	c.emit(opElement, slot, len(indexes))
+}
+
+// call compiles a call to a built-in function, a sub or a function, with
+// its arguments pushed in order.
+func (c *compiler) call(node Node, name string, arguments []Node, value bool) {
+	for _, argument := range arguments {
+		c.expression(argument)
+	}
+
+	if b, ok := builtins[name]; ok && value {
+		c.emit(opBuiltin, c.constant(&builtinCall{name: name, builtin: b}), len(arguments))
+		return
+	}
+
+	site := &callSite{
+		node:      node,
+		name:      name,
+		procedure: c.procedureSlot(name),
+		variable:  c.variable(name),
+		value:     value,
+	}
+	c.emit(opCall, c.constant(site), len(arguments))
+}
+
+// number pushes a number literal, or fails where it is used when it cannot
+// be parsed.
+func (c *compiler) number(value Value, err error) {
+	if err != nil {
+		c.emit(opFail, c.constant(err), 0)
+		return
+	}
+	c.emit(opLiteral, c.literal(value), 0)
+}
+
+// expression compiles an arithmetic expression or a condition, leaving its
+// value on the stack.
+func (c *compiler) expression(node Node) {
+	switch e := node.(type) {
+	case ArithmeticExpression:
+		if e.Left == nil {
+			c.expression(e.Right)
+			c.emit(opNegate, 0, 0)
+			return
+		}
+		c.expression(e.Left)
+		c.expression(e.Right)
+		c.emit(opArithmetic, int(e.Operator), 0)
+	case ConditionalExpression:
+		if e.Operator == Not {
+			c.expression(e.Right)
+			c.emit(opNot, 0, 0)
+			return
+		}
+		if e.Left == nil {
+			c.emit(opLiteral, c.literal(Value{}), 0)
+		} else {
+			c.expression(e.Left)
+		}
+		c.expression(e.Right)
+		c.emit(opCompare, int(e.Operator), 0)
+	case Variable:
+		slot := c.variable(e.Name)
+		if e.Indexes != nil {
+			c.element(slot, e.Indexes)
+			return
+		}
+		c.emit(opLoad, slot, int(loadValue))
+	case CallExpression:
+		c.call(e, e.Name, e.Arguments, true)
+	case ParenthesizedExpression:
+		c.expression(e.Expression)
+	case IntegerNumber:
+		n, err := strconv.Atoi(e.Value)
+		c.number(IntegerValue(n), err)
+	case RealNumber:
+		x, err := parseRealNumber(e.Value)
+		c.number(RealValue(x), err)
+	case String:
+		c.emit(opLiteral, c.literal(StringValue(strings.ReplaceAll(e.Value, "\"", ""))), 0)
+	default:
+		c.emit(opFail, c.constant(fmt.Errorf("unable to evaluate %T", node)), 0)
+	}
+}
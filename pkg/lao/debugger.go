@@ -0,0 +1,330 @@
+package lao
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Debugger is called before each statement a program runs, and before
+// loops test their condition. While and for loops are only seen at their
+// tests, once per iteration. Labels and the declarations of subs and
+// functions do not run, so it is not called for them. An error it returns
+// stops the program with that error.
+type Debugger interface {
+	Before(Pause) error
+}
+
+// Pause is a program stopped before a statement. It is only valid until the
+// debugger returns.
+type Pause interface {
+	// Statement returns the statement about to run, or the loop about to
+	// test its condition.
+	Statement() Node
+	// Position returns the line and column of the statement, or of the
+	// condition of a do loop tested at its end.
+	Position() (line, column int)
+	// Depth returns how many gosubs and calls to subs and functions are
+	// running.
+	Depth() int
+	// Variables returns a copy of the variables the statement sees: the
+	// globals and the locals of the running sub or function.
+	Variables() map[string]Value
+	// SetVariable changes a variable the statement sees, or defines a new
+	// global. The value must have the type of the variable.
+	SetVariable(name string, value Value) error
+}
+
+// stopAt is the statement a pause is at. Test is set when it is a loop
+// about to test its condition.
+type stopAt struct {
+	statement Node
+	test      bool
+}
+
+func (s stopAt) Statement() Node {
+	return s.statement
+}
+
+func (s stopAt) Position() (int, int) {
+	if d, ok := s.statement.(DoStatement); ok && s.test {
+		return position(d.Condition)
+	}
+	return position(s.statement)
+}
+
+// runs reports whether a statement runs. Labels and declarations are only
+// places to jump to and call.
+func runs(statement Node) bool {
+	switch statement.(type) {
+	case LabelStatement, SubStatement, FunctionStatement:
+		return false
+	}
+	return true
+}
+
+// pausesAt reports whether a debugger is called at a step of the statement,
+// made at the test of its condition when test is set.
+func pausesAt(statement Node, test bool) bool {
+	switch statement.(type) {
+	case WhileStatement, ForStatement:
+		return test
+	}
+	return runs(statement)
+}
+
+// checkVariable checks a debugger can give value to the variable named
+// name, whose value is current.
+func checkVariable(name string, current, value Value) error {
+	if implicitType(name) == 0 {
+		return fmt.Errorf("%q is not the name of a variable", name)
+	}
+
+	if current.Type() == ValueArray {
+		return runtimeErrorf(ErrorType, "array %s needs an index", name)
+	}
+
+	expected := current.Type()
+	if expected == ValueNone {
+		expected = valueType(implicitType(name))
+	}
+	if value.Type() != expected {
+		return runtimeErrorf(ErrorType, "variable %s is %s but got %s", name, expected, value.Type())
+	}
+	return nil
+}
+
+// Session is a debugger that pauses a program at breakpoints, or at the
+// next statement after a step, and hands the pause to a front-end. It
+// starts paused before the first statement.
+type Session interface {
+	Debugger
+	// Break adds a breakpoint before the statements starting on line.
+	Break(line int)
+	// BreakAtLabel adds a breakpoint on the line of the first statement
+	// after a label of the program, and returns that line.
+	BreakAtLabel(label string) (int, error)
+	// Clear removes the breakpoint on line.
+	Clear(line int)
+	// Breakpoints returns the lines with a breakpoint in order.
+	Breakpoints() []int
+	// Step pauses at the next statement, going into gosubs and calls.
+	Step()
+	// Next pauses at the next statement on another line, stepping over
+	// gosubs and calls.
+	Next()
+	// Continue runs until a breakpoint.
+	Continue()
+}
+
+// sessionMode is how a session goes on after a pause.
+type sessionMode int
+
+const (
+	modeStep sessionMode = iota
+	modeNext
+	modeContinue
+)
+
+type session struct {
+	program     []Node
+	stop        func(Pause) error
+	breakpoints map[int]bool
+	mode        sessionMode
+	line        int  // line of the last pause
+	depth       int  // depth of the last pause
+	left        bool // a statement on another line ran since the last pause
+}
+
+// NewSession creates a session debugging the program. Stop is called with
+// each pause, and chooses how the program goes on by calling Step, Next or
+// Continue before it returns. Otherwise it goes on as it did before the
+// pause. An error stop returns stops the program.
+func NewSession(program []Node, stop func(Pause) error) Session {
+	return &session{
+		program:     program,
+		stop:        stop,
+		breakpoints: map[int]bool{},
+		left:        true,
+	}
+}
+
+func (s *session) Before(p Pause) error {
+	line, _ := p.Position()
+	depth := p.Depth()
+	if line != s.line {
+		s.left = true
+	}
+
+	pause := s.left && s.breakpoints[line]
+	switch s.mode {
+	case modeStep:
+		pause = true
+	case modeNext:
+		pause = pause || depth < s.depth || depth == s.depth && line != s.line
+	}
+	if !pause {
+		return nil
+	}
+
+	s.line, s.depth, s.left = line, depth, false
+	return s.stop(p)
+}
+
+func (s *session) Break(line int) {
+	s.breakpoints[line] = true
+}
+
+func (s *session) BreakAtLabel(label string) (int, error) {
+	for index, statement := range s.program {
+		if l, ok := statement.(LabelStatement); !ok || l.Name != label {
+			continue
+		}
+
+		for _, next := range s.program[index+1:] {
+			if runs(next) {
+				line, _ := position(next)
+				s.Break(line)
+				return line, nil
+			}
+		}
+		return 0, fmt.Errorf("no statements after label %s", label)
+	}
+
+	return 0, fmt.Errorf("no label named %s", label)
+}
+
+func (s *session) Clear(line int) {
+	delete(s.breakpoints, line)
+}
+
+func (s *session) Breakpoints() []int {
+	lines := make([]int, 0, len(s.breakpoints))
+	for line := range s.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+func (s *session) Step() {
+	s.mode = modeStep
+}
+
+func (s *session) Next() {
+	s.mode = modeNext
+}
+
+func (s *session) Continue() {
+	s.mode = modeContinue
+}
+
+// walkerPause is a pause of the tree-walker.
+type walkerPause struct {
+	stopAt
+	i *interpreter
+}
+
+func (p *walkerPause) Depth() int {
+	return len(p.i.calls)
+}
+
+// locals returns the variables of the running sub or function, or nil.
+func (p *walkerPause) locals() map[string]Value {
+	if n := len(p.i.calls); n > 0 {
+		return p.i.calls[n-1].locals
+	}
+	return nil
+}
+
+func (p *walkerPause) Variables() map[string]Value {
+	variables := p.i.Variables()
+	for name, value := range p.locals() {
+		variables[name] = copyValue(value)
+	}
+	return variables
+}
+
+func (p *walkerPause) SetVariable(name string, value Value) error {
+	if locals := p.locals(); locals != nil {
+		if current, ok := locals[name]; ok {
+			if err := checkVariable(name, current, value); err != nil {
+				return err
+			}
+			locals[name] = value
+			return nil
+		}
+	}
+
+	current, ok := p.i.symbols[name]
+	if err := checkVariable(name, current, value); err != nil {
+		return err
+	}
+	if !ok {
+		if err := p.i.define(p.i.ip, name); err != nil {
+			return err
+		}
+	}
+	p.i.symbols[name] = value
+	return nil
+}
+
+// vmPause is a pause of the virtual machine at the instruction pc.
+type vmPause struct {
+	stopAt
+	v  *vm
+	pc int
+}
+
+func (p *vmPause) Depth() int {
+	return len(p.v.frames)
+}
+
+// routine returns the running sub or function, or nil. Gosubs run the
+// code of the top level even when they are made from a sub.
+func (p *vmPause) routine() *routine {
+	if n := len(p.v.frames); n > 0 {
+		return p.v.frames[n-1].routine
+	}
+	return nil
+}
+
+func (p *vmPause) Variables() map[string]Value {
+	variables := p.v.Variables()
+	if r := p.routine(); r != nil {
+		for local, value := range p.v.locals {
+			if name := r.names[local]; name != "" && value.Type() != ValueNone {
+				variables[name] = copyValue(value)
+			}
+		}
+	}
+	return variables
+}
+
+func (p *vmPause) SetVariable(name string, value Value) error {
+	if r := p.routine(); r != nil {
+		if local, ok := r.slots[name]; ok && p.v.locals[local].Type() != ValueNone {
+			if err := checkVariable(name, p.v.locals[local], value); err != nil {
+				return err
+			}
+			p.v.locals[local] = value
+			return nil
+		}
+	}
+
+	slot, ok := p.v.globals[name]
+	current := Value{}
+	if ok {
+		current = p.v.variables[slot]
+	}
+	if err := checkVariable(name, current, value); err != nil {
+		return err
+	}
+
+	if !ok {
+		slot = p.v.global(name)
+		for len(p.v.variables) < len(p.v.names) {
+			p.v.variables = append(p.v.variables, Value{})
+		}
+	}
+	return p.v.assign(p.pc, slot, value)
+}
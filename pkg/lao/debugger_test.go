@@ -0,0 +1,169 @@
+package lao_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+const debuggedProgram = `a = 1
+gosub double
+print a
+for c = 1 to 2
+a = a .add. c
+next c
+end.
+double:
+a = a .mul. 2
+return`
+
+var errQuit = errors.New("quit")
+
+func TestSession(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		program  string
+		setup    func(lao.Session)
+		stop     func(lao.Session, lao.Pause) error
+		pauses   []int
+		expected string
+		err      error
+	}{
+		{
+			desc:     "step",
+			program:  debuggedProgram,
+			pauses:   []int{1, 2, 9, 10, 3, 4, 5, 4, 5, 4, 7},
+			expected: "2\n",
+		},
+		{
+			desc:    "next steps over gosubs",
+			program: debuggedProgram,
+			stop: func(s lao.Session, p lao.Pause) error {
+				s.Next()
+				return nil
+			},
+			pauses:   []int{1, 2, 3, 4, 5, 4, 5, 4, 7},
+			expected: "2\n",
+		},
+		{
+			desc:    "breakpoint on a label",
+			program: debuggedProgram,
+			setup: func(s lao.Session) {
+				line, err := s.BreakAtLabel("double")
+				assert.NoError(t, err)
+				assert.Equal(t, 9, line)
+			},
+			stop: func(s lao.Session, p lao.Pause) error {
+				s.Continue()
+				return nil
+			},
+			pauses:   []int{1, 9},
+			expected: "2\n",
+		},
+		{
+			desc:    "breakpoint in a loop",
+			program: debuggedProgram,
+			setup:   func(s lao.Session) { s.Break(5) },
+			stop: func(s lao.Session, p lao.Pause) error {
+				s.Continue()
+				return nil
+			},
+			pauses:   []int{1, 5, 5},
+			expected: "2\n",
+		},
+		{
+			desc:    "do loops pause at their condition",
+			program: "c = 1\ndo\nc = c .add. 1\nloop until c .eq. 3",
+			pauses:  []int{1, 2, 3, 4, 3, 4},
+		},
+		{
+			desc:    "modify a variable",
+			program: debuggedProgram,
+			setup:   func(s lao.Session) { s.Break(3) },
+			stop: func(s lao.Session, p lao.Pause) error {
+				s.Continue()
+				if line, _ := p.Position(); line == 3 {
+					return p.SetVariable("a", lao.IntegerValue(10))
+				}
+				return nil
+			},
+			pauses:   []int{1, 3},
+			expected: "10\n",
+		},
+		{
+			desc:    "modify a local",
+			program: "show(5)\nsub show(d)\ne = d .add. 1\nprint e\nend sub",
+			setup:   func(s lao.Session) { s.Break(4) },
+			stop: func(s lao.Session, p lao.Pause) error {
+				s.Continue()
+				if line, _ := p.Position(); line != 4 {
+					return nil
+				}
+
+				variables := p.Variables()
+				assert.Equal(t, lao.IntegerValue(5), variables["d"])
+				assert.Equal(t, lao.IntegerValue(6), variables["e"])
+				assert.Equal(t, 1, p.Depth())
+				assert.EqualError(t, p.SetVariable("e", lao.StringValue("x")), "variable e is integer but got string")
+				return p.SetVariable("e", lao.IntegerValue(7))
+			},
+			pauses:   []int{1, 4},
+			expected: "7\n",
+		},
+		{
+			desc:    "quit",
+			program: debuggedProgram,
+			stop: func(s lao.Session, p lao.Pause) error {
+				if line, _ := p.Position(); line == 3 {
+					return errQuit
+				}
+				return nil
+			},
+			pauses: []int{1, 2, 9, 10, 3},
+			err:    errQuit,
+		},
+	}
+	for _, engine := range engines {
+		for _, tC := range testCases {
+			t.Run(engine.name+"/"+tC.desc, func(t *testing.T) {
+				statements, err := lao.NewParser(
+					lao.NewTokenizer(strings.NewReader(tC.program)),
+				).Parse()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var pauses []int
+				var session lao.Session
+				session = lao.NewSession(statements, func(p lao.Pause) error {
+					line, _ := p.Position()
+					pauses = append(pauses, line)
+					if tC.stop != nil {
+						return tC.stop(session, p)
+					}
+					return nil
+				})
+				if tC.setup != nil {
+					tC.setup(session)
+				}
+
+				out := new(bytes.Buffer)
+				interpreter := lao.NewInterpreter(out, append(engine.options, lao.WithDebugger(session))...)
+				err = interpreter.Execute(statements)
+				if tC.err != nil {
+					assert.True(t, errors.Is(err, tC.err), "got %v", err)
+				} else if err != io.EOF {
+					assert.NoError(t, err)
+				}
+
+				assert.Equal(t, tC.pauses, pauses)
+				assert.Equal(t, tC.expected, out.String())
+			})
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package lao
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrorKind classifies runtime errors
+type ErrorKind int
+
+// ErrorKind
+const (
+	_                  ErrorKind = iota
+	ErrorType                    // a value of the wrong type
+	ErrorName                    // an undefined or redefined variable, label or procedure
+	ErrorRange                   // an array index outside its bounds
+	ErrorArgument                // a value a built-in function or statement does not accept
+	ErrorArithmetic              // a division by zero
+	ErrorControl                 // a return or call that cannot complete
+	ErrorStackOverflow           // too many gosubs or calls active at once
+	ErrorInput                   // a read statement that cannot read its value
+	ErrorLimit                   // a program stopped by a limit of the interpreter
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorType:
+		return "type error"
+	case ErrorName:
+		return "name error"
+	case ErrorRange:
+		return "range error"
+	case ErrorArgument:
+		return "argument error"
+	case ErrorArithmetic:
+		return "arithmetic error"
+	case ErrorControl:
+		return "control error"
+	case ErrorStackOverflow:
+		return "stack overflow"
+	case ErrorInput:
+		return "input error"
+	case ErrorLimit:
+		return "limit exceeded"
+	}
+	return "runtime error"
+}
+
+// RuntimeError is returned by an interpreter when a statement fails. It
+// carries the innermost statement that failed, where it starts in the
+// source and the gosubs and calls that were active, innermost first.
+type RuntimeError struct {
+	Kind      ErrorKind
+	Statement Node
+	Line      int
+	Column    int
+	Stack     []StackFrame
+	Err       error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s at line %d column %d", e.Err, e.Line, e.Column)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// StackFrame is a gosub, or a call to a sub or function, that was active
+// when a runtime error happened. Name is the label or procedure called, and
+// the position is the one of the call.
+type StackFrame struct {
+	Call   Node
+	Name   string
+	Line   int
+	Column int
+}
+
+// Limit is a bound on what a program may do before the interpreter stops
+// it.
+type Limit int
+
+// Limit
+const (
+	_                  Limit = iota
+	LimitContext             // the context the program runs in is done
+	LimitSteps               // the number of steps set with WithMaxSteps
+	LimitOutput              // the bytes of output set with WithMaxOutput
+	LimitStringLength        // the length of strings set with WithMaxStringLength
+	LimitVariables           // the number of variables set with WithMaxVariables
+	LimitArrayElements       // the elements of arrays set with WithMaxArrayElements
+)
+
+func (l Limit) String() string {
+	switch l {
+	case LimitContext:
+		return "context"
+	case LimitSteps:
+		return "steps"
+	case LimitOutput:
+		return "output"
+	case LimitStringLength:
+		return "string length"
+	case LimitVariables:
+		return "variables"
+	case LimitArrayElements:
+		return "array elements"
+	}
+	return "limit"
+}
+
+// LimitError is the cause of a runtime error of kind ErrorLimit. IP is the
+// instruction pointer where the program stopped: the address of a bytecode
+// instruction, or the index of a top level statement with the tree-walker.
+type LimitError struct {
+	Limit Limit
+	IP    int
+	Err   error
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s (instruction %d)", e.Err, e.IP)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// limitError creates the runtime error of a limit exceeded at ip.
+func limitError(limit Limit, ip int, err error) error {
+	return &RuntimeError{
+		Kind: ErrorLimit,
+		Err:  &LimitError{Limit: limit, IP: ip, Err: err},
+	}
+}
+
+// runtimeErrorf creates a runtime error of the kind. Its position is filled
+// in by the statement that returns it.
+func runtimeErrorf(kind ErrorKind, format string, args ...interface{}) error {
+	return &RuntimeError{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// locateError gives an error returned while running the statement its
+// position and the current call stack. Errors already located by a nested
+// statement and the errors used for control flow are returned unchanged.
+func (i *interpreter) locateError(statement Node, err error) error {
+	runtimeError := unlocatedError(err)
+	if runtimeError == nil {
+		return err
+	}
+
+	calls := make([]Node, len(i.calls))
+	for index, f := range i.calls {
+		calls[index] = f.call
+	}
+
+	runtimeError.locate(statement, calls)
+	return runtimeError
+}
+
+// unlocatedError returns the runtime error for an error that has no
+// position yet, or nil when the error must be returned unchanged.
+func unlocatedError(err error) *RuntimeError {
+	switch err {
+	case nil, io.EOF, errExit, errReturn:
+		return nil
+	}
+
+	var runtimeError *RuntimeError
+	if !errors.As(err, &runtimeError) {
+		return &RuntimeError{Err: err}
+	} else if runtimeError.Statement != nil {
+		return nil
+	}
+	return runtimeError
+}
+
+// locate sets the statement that failed and the stack of the gosubs and
+// calls that were active, given outermost first.
+func (e *RuntimeError) locate(statement Node, calls []Node) {
+	e.Statement = statement
+	e.Line, e.Column = position(statement)
+
+	e.Stack = make([]StackFrame, 0, len(calls))
+	for index := len(calls) - 1; index >= 0; index-- {
+		call := calls[index]
+
+		frame := StackFrame{Call: call}
+		frame.Line, frame.Column = position(call)
+		switch c := call.(type) {
+		case GosubStatement:
+			frame.Name = c.Label
+		case CallStatement:
+			frame.Name = c.Name
+		case CallExpression:
+			frame.Name = c.Name
+		}
+
+		e.Stack = append(e.Stack, frame)
+	}
+}
+
+// position returns the line and column a node starts at, or zeros when it
+// has no tokens.
+func position(node Node) (int, int) {
+	if node == nil {
+		return 0, 0
+	}
+	if tokens := node.Tokens(); len(tokens) > 0 {
+		return tokens[0].Line, tokens[0].Column
+	}
+	return 0, 0
+}
+
+// ParseError is returned by a parser for a token it cannot accept. Expected
+// lists the kinds of token that were accepted at that point, when they are
+// known.
+type ParseError struct {
+	Line     int
+	Column   int
+	Expected []Kind
+	Found    Kind
+	Value    string
+	Err      error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at line %d column %d", e.Err, e.Line, e.Column)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseErrors is returned by a parser in recovery mode with every syntax
+// error found, in the order they appear in the source.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	messages := make([]string, len(e))
+	for index, err := range e {
+		messages[index] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// errorAt creates a parse error for the token found where something else
+// was expected.
+func errorAt(found Token, expected []Kind, format string, args ...interface{}) error {
+	return &ParseError{
+		Line:     found.Line,
+		Column:   found.Column,
+		Expected: expected,
+		Found:    found.Kind,
+		Value:    found.Value,
+		Err:      fmt.Errorf(format, args...),
+	}
+}
+
+// illegalTokenError creates the parse error for a token of KindIllegal.
+func illegalTokenError(token Token) error {
+	if strings.HasPrefix(token.Value, `"`) {
+		return errorAt(token, nil, "unterminated string %s", strings.TrimRight(token.Value, "\r"))
+	}
+	return errorAt(token, nil, "illegal character %q", token.Value)
+}
@@ -0,0 +1,87 @@
+package lao_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+func TestRuntimeError(t *testing.T) {
+	type frame struct {
+		Name   string
+		Line   int
+		Column int
+	}
+
+	testCases := []struct {
+		desc    string
+		program string
+		kind    lao.ErrorKind
+		line    int
+		column  int
+		stack   []frame
+	}{
+		{
+			desc:    "undefined variable",
+			program: "print \"start\"\n  a = b",
+			kind:    lao.ErrorName,
+			line:    2,
+			column:  3,
+		},
+		{
+			desc:    "innermost statement of a block",
+			program: "for c = 1 to 2\nif c .eq. 2 then a = c .div. 0\nnext c",
+			kind:    lao.ErrorArithmetic,
+			line:    2,
+			column:  18,
+		},
+		{
+			desc:    "inside a gosub and a sub",
+			program: "gosub first\nend.\nfirst:\nshow(1)\nreturn\nsub show(a)\ndim b(1)\nb(a .add. 1) = 0\nend sub",
+			kind:    lao.ErrorRange,
+			line:    8,
+			column:  1,
+			stack: []frame{
+				{Name: "show", Line: 4, Column: 1},
+				{Name: "first", Line: 1, Column: 1},
+			},
+		},
+	}
+	for _, engine := range engines {
+		for _, tC := range testCases {
+			t.Run(engine.name+"/"+tC.desc, func(t *testing.T) {
+				statements, err := lao.NewParser(
+					lao.NewTokenizer(strings.NewReader(tC.program)),
+				).Parse()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				err = lao.NewInterpreter(new(bytes.Buffer), engine.options...).Execute(statements)
+
+				var runtimeError *lao.RuntimeError
+				if !errors.As(err, &runtimeError) {
+					t.Fatalf("expected a runtime error but got %v", err)
+				}
+
+				assert.Equal(t, tC.kind, runtimeError.Kind)
+				assert.Equal(t, tC.line, runtimeError.Line)
+				assert.Equal(t, tC.column, runtimeError.Column)
+				assert.NotNil(t, runtimeError.Statement)
+
+				stack := []frame{}
+				for _, f := range runtimeError.Stack {
+					stack = append(stack, frame{Name: f.Name, Line: f.Line, Column: f.Column})
+				}
+				if tC.stack == nil {
+					tC.stack = []frame{}
+				}
+				assert.Equal(t, tC.stack, stack)
+			})
+		}
+	}
+}
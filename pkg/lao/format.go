@@ -0,0 +1,317 @@
+package lao
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format writes the program in canonical form: lowercase keywords, names
+// and operators, one space around = and operators, labels at column 0 and
+// the bodies of blocks indented with a tab. Rem comments are kept, and
+// runs of blank lines between statements become a single one. Nodes
+// without tokens, as in unmarshalled programs, are laid out from their
+// fields alone.
+func Format(w io.Writer, program []Node) error {
+	f := &formatter{}
+	if err := f.block(program, 0); err != nil {
+		return err
+	}
+	if f.Len() > 0 {
+		f.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, f.String())
+	return err
+}
+
+// formatter writes each statement without the newline that ends it, so a
+// rem that follows on the same line can stay there.
+type formatter struct {
+	strings.Builder
+	last int // line the previous statement or block header ended on
+}
+
+// block writes the statements at the indentation depth.
+func (f *formatter) block(statements []Node, depth int) error {
+	for _, statement := range statements {
+		tokens := statement.Tokens()
+		line := tokenLine(tokens, 0)
+		if rem, ok := statement.(RemStatement); ok && line > 0 && line == f.last {
+			f.WriteString(" ")
+			f.rem(rem)
+			continue
+		}
+
+		if f.Len() > 0 {
+			f.WriteString("\n")
+			if f.last > 0 && line > f.last+1 {
+				f.WriteString("\n")
+			}
+		}
+		if _, ok := statement.(LabelStatement); !ok {
+			f.indent(depth)
+		}
+
+		f.last = line
+		if err := f.statement(statement, depth); err != nil {
+			return err
+		}
+		f.last = tokenLine(tokens, len(tokens)-1)
+	}
+	return nil
+}
+
+// tokenLine returns the line of the token at index, or 0 when there is no
+// such token, as in programs unmarshalled without their tokens.
+func tokenLine(tokens []Token, index int) int {
+	if index < 0 || index >= len(tokens) {
+		return 0
+	}
+	return tokens[index].Line
+}
+
+// implicit reports whether a name has the type its first letter gives it,
+// for nodes without the tokens that tell whether it was declared with as.
+func implicit(name string, t VariableType) bool {
+	return name != "" && implicitType(name) == t
+}
+
+func (f *formatter) indent(depth int) {
+	f.WriteString(strings.Repeat("\t", depth))
+}
+
+func (f *formatter) statement(statement Node, depth int) error {
+	switch s := statement.(type) {
+	case AssignmentStatement:
+		return f.write(s.Variable, " = ", s.ArithmeticExpression)
+	case PrintStatement:
+		if s.Argumenent == nil {
+			return f.write("print")
+		}
+		return f.write("print ", s.Argumenent)
+	case ReadStatement:
+		return f.write("read ", s.Variable)
+	case RemStatement:
+		f.rem(s)
+	case EndStatement:
+		return f.write("end.")
+	case LabelStatement:
+		return f.write(s.Name, ":")
+	case GotoStatement:
+		return f.write("goto ", s.Label)
+	case GosubStatement:
+		return f.write("gosub ", s.Label)
+	case ReturnStatement:
+		if s.Value == nil {
+			return f.write("return")
+		}
+		return f.write("return ", s.Value)
+	case ExitStatement:
+		return f.write("exit")
+	case DimStatement:
+		return f.write("dim ", s.Variable.Name, "(", s.Bounds, ")")
+	case CallStatement:
+		return f.write(s.Name, "(", s.Arguments, ")")
+	case IfStatement:
+		return f.ifStatement(s, depth)
+	case ForStatement:
+		err := f.write("for ", s.Variable, " = ", s.From, " to ", s.To)
+		if err == nil && s.Step != nil {
+			err = f.write(" step ", s.Step)
+		}
+		if err != nil {
+			return err
+		}
+		return f.body(s.Body, depth, "next ", s.Variable.Name)
+	case WhileStatement:
+		if err := f.write("while ", s.Condition); err != nil {
+			return err
+		}
+		return f.body(s.Body, depth, "wend")
+	case DoStatement:
+		f.WriteString("do")
+		return f.body(s.Body, depth, "loop until ", s.Condition)
+	case SubStatement:
+		if err := f.write("sub ", s.Name, "(", s.Parameters, ")"); err != nil {
+			return err
+		}
+		return f.body(s.Body, depth, "end sub")
+	case FunctionStatement:
+		if err := f.write("function ", s.Name, "(", s.Parameters, ")"); err != nil {
+			return err
+		}
+		if declaresType(s.tokens) || len(s.tokens) == 0 && !implicit(s.Name, s.Type) {
+			f.WriteString(" as " + s.Type.String())
+		}
+		return f.body(s.Body, depth, "end function")
+	default:
+		return fmt.Errorf("cannot format %T", statement)
+	}
+	return nil
+}
+
+// body writes the statements of a block one level deeper than the depth,
+// followed by the closing keywords on a line of their own.
+func (f *formatter) body(statements []Node, depth int, closing ...interface{}) error {
+	if err := f.block(statements, depth+1); err != nil {
+		return err
+	}
+	f.WriteString("\n")
+	f.indent(depth)
+	return f.write(closing...)
+}
+
+// ifStatement keeps the one line form when the statement after then was on
+// the same line.
+func (f *formatter) ifStatement(s IfStatement, depth int) error {
+	if err := f.write("if ", s.Condition, " then"); err != nil {
+		return err
+	}
+
+	header := len(s.Condition.Tokens()) + 2
+	then := tokenLine(s.tokens, header-1)
+	if len(s.Then) == 1 && len(s.ElseIfs) == 0 && s.Else == nil &&
+		then > 0 && tokenLine(s.Then[0].Tokens(), 0) == then {
+		f.WriteString(" ")
+		return f.statement(s.Then[0], depth)
+	}
+
+	if err := f.block(s.Then, depth+1); err != nil {
+		return err
+	}
+	next := header + len(appendTokens(nil, s.Then))
+
+	for _, clause := range s.ElseIfs {
+		f.WriteString("\n")
+		f.indent(depth)
+		if err := f.write("elseif ", clause.Condition, " then"); err != nil {
+			return err
+		}
+
+		f.last = tokenLine(clause.tokens, 0)
+		if err := f.block(clause.Body, depth+1); err != nil {
+			return err
+		}
+		next += len(clause.tokens)
+	}
+
+	if s.Else != nil {
+		f.WriteString("\n")
+		f.indent(depth)
+		f.WriteString("else")
+
+		f.last = tokenLine(s.tokens, next)
+		if err := f.block(s.Else, depth+1); err != nil {
+			return err
+		}
+	}
+
+	f.WriteString("\n")
+	f.indent(depth)
+	f.WriteString("endif")
+	return nil
+}
+
+// write writes each part in turn. Strings are written as they are, and
+// nodes and lists of them as expressions.
+func (f *formatter) write(parts ...interface{}) error {
+	for _, part := range parts {
+		var err error
+		switch p := part.(type) {
+		case string:
+			f.WriteString(p)
+		case []Node:
+			for index, node := range p {
+				if index > 0 {
+					f.WriteString(", ")
+				}
+				if err = f.expression(node); err != nil {
+					break
+				}
+			}
+		case []Variable:
+			for index, variable := range p {
+				if index > 0 {
+					f.WriteString(", ")
+				}
+				f.WriteString(variable.Name)
+				// The tokens of a parameter are its name, as and the type
+				if len(variable.tokens) > 1 || len(variable.tokens) == 0 && !implicit(variable.Name, variable.Type) {
+					f.WriteString(" as " + variable.Type.String())
+				}
+			}
+		case Node:
+			err = f.expression(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *formatter) expression(expression Node) error {
+	switch e := expression.(type) {
+	case ArithmeticExpression:
+		if e.Left == nil {
+			return f.write("-", e.Right)
+		}
+		return f.write(e.Left, " "+e.Operator.String()+" ", e.Right)
+	case ConditionalExpression:
+		if e.Left == nil {
+			return f.write(e.Operator.String()+" ", e.Right)
+		}
+		return f.write(e.Left, " "+e.Operator.String()+" ", e.Right)
+	case ParenthesizedExpression:
+		return f.write("(", e.Expression, ")")
+	case IntegerNumber:
+		f.WriteString(e.Value)
+	case RealNumber:
+		f.WriteString(strings.ToLower(e.Value))
+	case String:
+		f.WriteString(e.Value)
+	case Variable:
+		f.WriteString(e.Name)
+		if e.Indexes != nil {
+			return f.write("(", e.Indexes, ")")
+		}
+	case CallExpression:
+		return f.write(e.Name, "(", e.Arguments, ")")
+	default:
+		return fmt.Errorf("cannot format %T", expression)
+	}
+	return nil
+}
+
+// rem writes the comment with the spacing it had between its words.
+func (f *formatter) rem(r RemStatement) {
+	f.WriteString("rem")
+
+	end := 0
+	for index, token := range r.tokens {
+		if index > 0 {
+			spaces := token.Column - end
+			if spaces < 0 || index == 1 && spaces < 1 {
+				spaces = 1
+			}
+			f.WriteString(strings.Repeat(" ", spaces) + token.Value)
+		}
+		end = token.Column + utf8.RuneCountInString(token.Value)
+	}
+}
+
+// declaresType reports whether the tokens of a function have an as clause
+// after its parameters.
+func declaresType(tokens []Token) bool {
+	for index, token := range tokens {
+		if token.Kind == KindRightParenthesis {
+			next := index + 1
+			return next < len(tokens) &&
+				tokens[next].Kind == KindKeyword &&
+				strings.ToLower(tokens[next].Value) == "as"
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package lao_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+func TestFormat(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "keywords, names and operators",
+			input:    "PrinT \"Hola\"\nA=B .ADD. 1.5E3\nREAD gX\nif .NOT. a .GT. 5 .Or. b .eq. 3 THEN GOTO Done\nDone:\nEND.",
+			expected: "print \"Hola\"\na = b .add. 1.5e3\nread gx\nif .not. a .gt. 5 .or. b .eq. 3 then goto Done\nDone:\nend.\n",
+		},
+		{
+			desc:     "negation, parentheses and calls",
+			input:    "a = -(b.sub.-2) .mul. max(c,  d)\ndim gm(3,3)\ngm(1,2) = abs(-a)",
+			expected: "a = -(b .sub. -2) .mul. max(c, d)\ndim gm(3, 3)\ngm(1, 2) = abs(-a)\n",
+		},
+		{
+			desc:     "rem comments",
+			input:    "REM  Keep   this, \"as is\"\nprint 1   rem after a statement\nrem",
+			expected: "rem  Keep   this, \"as is\"\nprint 1 rem after a statement\nrem\n",
+		},
+		{
+			desc:     "blank lines",
+			input:    "a = 1\n\n\n\nprint a\n\nend.\n\n",
+			expected: "a = 1\n\nprint a\n\nend.\n",
+		},
+		{
+			desc:     "loops",
+			input:    "for c = 1 to 10 step 2\nwhile a .lt. c\n  a = a .add. 1\nwend\nnext c\ndo\nexit\nloop until a .gt. 3",
+			expected: "for c = 1 to 10 step 2\n\twhile a .lt. c\n\t\ta = a .add. 1\n\twend\nnext c\ndo\n\texit\nloop until a .gt. 3\n",
+		},
+		{
+			desc:     "block if",
+			input:    "if a .gt. 1 then\nprint 1\nelseif a .eq. 1 then\nprint 0\nelse\nif a .lt. 0 then print -1\nend if",
+			expected: "if a .gt. 1 then\n\tprint 1\nelseif a .eq. 1 then\n\tprint 0\nelse\n\tif a .lt. 0 then print -1\nendif\n",
+		},
+		{
+			desc:     "labels stay at column 0",
+			input:    "  again:\nfor c = 1 to 2\nprint c\nnext c",
+			expected: "again:\nfor c = 1 to 2\n\tprint c\nnext c\n",
+		},
+		{
+			desc:     "procedures",
+			input:    "SUB show(w, gx AS integer)\nprint w\nEND SUB\nfunction gsq(gx) as REAL\nreturn gx .mul. gx\nend function\nfunction twice(a)\nreturn a .add. a\nend function\nshow(\"x\", 1)",
+			expected: "sub show(w, gx as integer)\n\tprint w\nend sub\nfunction gsq(gx) as real\n\treturn gx .mul. gx\nend function\nfunction twice(a)\n\treturn a .add. a\nend function\nshow(\"x\", 1)\n",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			statements, err := lao.NewParser(lao.NewTokenizer(strings.NewReader(tC.input))).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := new(bytes.Buffer)
+			assert.NoError(t, lao.Format(out, statements))
+			assert.Equal(t, tC.expected, out.String())
+
+			// Formatting the result again changes nothing
+			statements, err = lao.NewParser(lao.NewTokenizer(bytes.NewReader(out.Bytes()))).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			again := new(bytes.Buffer)
+			assert.NoError(t, lao.Format(again, statements))
+			assert.Equal(t, tC.expected, again.String())
+		})
+	}
+}
+
+func TestFormatUnmarshalled(t *testing.T) {
+	program, err := lao.UnmarshalProgram([]byte(`[
+		{"type": "LabelStatement", "name": "start"},
+		{
+			"type": "IfStatement",
+			"condition": {
+				"type": "ConditionalExpression",
+				"operator": ".gt.",
+				"left": {"type": "Variable", "name": "a", "variableType": "integer"},
+				"right": {"type": "IntegerNumber", "value": "1"}
+			},
+			"then": [{"type": "PrintStatement", "argument": {"type": "String", "value": "\"big\""}}],
+			"else": [{"type": "RemStatement"}]
+		},
+		{
+			"type": "FunctionStatement",
+			"name": "twice",
+			"variableType": "real",
+			"parameters": [{"type": "Variable", "name": "s", "variableType": "integer"}],
+			"body": [{"type": "ReturnStatement", "value": {"type": "Variable", "name": "s", "variableType": "integer"}}]
+		}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(bytes.Buffer)
+	assert.NoError(t, lao.Format(out, program))
+	assert.Equal(t, "start:\nif a .gt. 1 then\n\tprint \"big\"\nelse\n\trem\nendif\nfunction twice(s as integer) as real\n\treturn s\nend function\n", out.String())
+}
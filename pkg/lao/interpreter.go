@@ -1,166 +1,292 @@
 package lao
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Interpreter executes the AST
 type Interpreter interface {
 	Execute([]Node) error
+	// ExecuteContext is Execute stopping between statements once ctx is
+	// done.
+	ExecuteContext(context.Context, []Node) error
+	// Continue runs statements after the ones already executed, keeping the
+	// variables and labels of previous calls. Labels may jump back into
+	// statements given to earlier calls.
+	Continue([]Node) error
+	// Variables returns a copy of the variables currently defined.
+	Variables() map[string]Value
+	// Reset forgets every variable, label and statement seen so far.
+	Reset()
 }
 
-func NewInterpreter(out io.Writer) Interpreter {
+// errExit is returned by an exit statement and stops the innermost loop
+var errExit = errors.New("exit outside of a loop")
+
+// errReturn is returned by a return statement and ends the innermost gosub
+var errReturn = errors.New("return")
+
+const defaultMaxCallDepth = 1000
+
+// InterpreterOption configures an interpreter
+type InterpreterOption func(*settings)
+
+// WithInput sets where read statements take their values from. The default
+// is the process standard input.
+func WithInput(r io.Reader) InterpreterOption {
+	return func(s *settings) {
+		s.in = bufio.NewReader(r)
+	}
+}
+
+// WithMaxCallDepth sets how many gosub calls may be active at once before
+// the program is stopped. The default is 1000.
+func WithMaxCallDepth(depth int) InterpreterOption {
+	return func(s *settings) {
+		s.maxCallDepth = depth
+	}
+}
+
+// WithRandomSeed seeds the numbers returned by rnd, so a program gives the
+// same results on every run. By default the seed is the current time.
+func WithRandomSeed(seed int64) InterpreterOption {
+	return func(s *settings) {
+		s.random = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithMaxSteps stops programs that take more than steps steps. Every
+// statement run and every test of a loop condition is a step, counted from
+// zero on each call to Execute or Continue. By default there is no limit.
+func WithMaxSteps(steps int) InterpreterOption {
+	return func(s *settings) {
+		s.limits.steps = steps
+	}
+}
+
+// WithMaxOutput stops programs that print more than bytes bytes, counted
+// from zero on each call to Execute or Continue. By default there is no
+// limit.
+func WithMaxOutput(bytes int) InterpreterOption {
+	return func(s *settings) {
+		s.limits.output = bytes
+	}
+}
+
+// WithMaxStringLength stops programs that make a string longer than bytes
+// bytes, by joining strings, reading a line or calling a built-in function.
+// By default there is no limit.
+func WithMaxStringLength(bytes int) InterpreterOption {
+	return func(s *settings) {
+		s.limits.stringLength = bytes
+	}
+}
+
+// WithMaxVariables stops programs that define more than count global
+// variables. The variables of subs and functions are not counted. By
+// default there is no limit.
+func WithMaxVariables(count int) InterpreterOption {
+	return func(s *settings) {
+		s.limits.variables = count
+	}
+}
+
+// WithMaxArrayElements stops programs whose arrays have more than count
+// elements in total at once. By default there is no limit.
+func WithMaxArrayElements(count int) InterpreterOption {
+	return func(s *settings) {
+		s.limits.arrayElements = count
+	}
+}
+
+// WithDebugger calls the debugger before each statement of the programs
+// the interpreter runs.
+func WithDebugger(debugger Debugger) InterpreterOption {
+	return func(s *settings) {
+		s.debugger = debugger
+	}
+}
+
+// WithTreeWalker runs programs by walking their statements instead of
+// compiling them to bytecode. It is slower, and is kept as the reference
+// the virtual machine must agree with.
+func WithTreeWalker() InterpreterOption {
+	return func(s *settings) {
+		s.treeWalker = true
+	}
+}
+
+// NewInterpreter creates an interpreter that prints to out. By default it
+// compiles the statements it is given and runs them on a virtual machine.
+func NewInterpreter(out io.Writer, options ...InterpreterOption) Interpreter {
+	s := settings{
+		out:          out,
+		maxCallDepth: defaultMaxCallDepth,
+	}
+
+	for _, option := range options {
+		option(&s)
+	}
+
+	if s.in == nil {
+		s.in = bufio.NewReader(os.Stdin)
+	}
+
+	if s.random == nil {
+		s.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+
+	if !s.treeWalker {
+		return newVM(s)
+	}
+
 	return &interpreter{
-		out:     out,
-		symbols: map[string]interface{}{},
-		labels:  map[string]int{},
+		settings:   s,
+		budget:     budget{max: s.limits},
+		symbols:    map[string]Value{},
+		labels:     map[string]int{},
+		procedures: map[string]procedure{},
 	}
 }
 
+// settings are the options shared by the tree-walker and the virtual
+// machine.
+type settings struct {
+	in           *bufio.Reader
+	out          io.Writer
+	maxCallDepth int
+	limits       limits
+	random       *rand.Rand
+	debugger     Debugger
+	treeWalker   bool
+}
+
 type interpreter struct {
-	symbols map[string]interface{}
-	labels  map[string]int
-	out     io.Writer
-	jump    bool
-	jumpTo  int
+	settings
+	budget
+	symbols    map[string]Value
+	labels     map[string]int
+	program    []Node
+	jump       bool
+	jumpTo     int
+	ip         int
+	calls      []*frame
+	procedures map[string]procedure
+}
+
+// frame is an entry of the call stack. A gosub pushes a frame without
+// locals; calls to subs and functions push frames with their own variables.
+type frame struct {
+	call    Node // gosub statement, call statement or call expression
+	address int  // top level statement that made the call
+	locals  map[string]Value
+	result  Value
+}
+
+// Array is the value of a variable declared with dim. Elements are stored
+// row by row, and each dimension goes from 0 to its bound.
+type Array struct {
+	Type     VariableType
+	Bounds   []int
+	Elements []Value
 }
 
-func (i *interpreter) evalauteArithmeticExpression(
-	vType VariableType,
-	exp interface{},
-) (interface{}, error) {
+// procedure is a declared sub or function
+type procedure struct {
+	name       string
+	parameters []Variable
+	body       []Node
+	function   bool
+	returns    VariableType
+}
 
-	switch e := exp.(type) {
+// evaluateExpression computes the value of an arithmetic expression or a
+// condition.
+func (i *interpreter) evaluateExpression(node Node) (Value, error) {
+	switch e := node.(type) {
 	case ArithmeticExpression:
-		left, err := i.evalauteArithmeticExpression(vType, e.Left)
+		if e.Left == nil {
+			right, err := i.evaluateExpression(e.Right)
+			if err != nil {
+				return Value{}, err
+			}
+			return right.Negate()
+		}
+
+		left, right, err := i.evaluateOperands(e.Left, e.Right)
 		if err != nil {
-			return nil, err
+			return Value{}, err
 		}
-		right, err := i.evalauteArithmeticExpression(vType, e.Right)
+
+		value, err := arithmetic(e.Operator, left, right)
 		if err != nil {
-			return nil, err
-		}
-
-		switch e.Operator {
-		case ArithmeticAdd:
-			switch l := left.(type) {
-			case int:
-				switch r := right.(type) {
-				case int:
-					return l + r, nil
-				case float64:
-					return float64(l) + r, nil
-				case string:
-					return fmt.Sprintf("%d%s", l, r), nil
-				}
-			case float64:
-				switch r := right.(type) {
-				case int:
-					return l + float64(r), nil
-				case float64:
-					return l + r, nil
-				case string:
-					return fmt.Sprintf("%.6f%s", l, r), nil
-				}
-			case string:
-				switch r := right.(type) {
-				case int:
-					return fmt.Sprintf("%s%d", l, r), nil
-				case float64:
-					return fmt.Sprintf("%s%.6f", l, r), nil
-				case string:
-					return l + r, nil
-				}
-			}
-		case ArithmeticSubtract:
-			switch l := left.(type) {
-			case int:
-				switch r := right.(type) {
-				case int:
-					return l - r, nil
-				case float64:
-					return float64(l) - r, nil
-				case string:
-					return nil, fmt.Errorf("Cannot substract string")
-				}
-			case float64:
-				switch r := right.(type) {
-				case int:
-					return l - float64(r), nil
-				case float64:
-					return l - r, nil
-				case string:
-					return nil, fmt.Errorf("Cannot substract string")
-				}
-			case string:
-				return nil, fmt.Errorf("Cannot substract from string")
-			}
-		case ArithmeticDivision:
-			switch l := left.(type) {
-			case int:
-				switch r := right.(type) {
-				case int:
-					return l / r, nil
-				case float64:
-					return float64(l) / r, nil
-				case string:
-					return nil, fmt.Errorf("Cannot divide string")
-				}
-			case float64:
-				switch r := right.(type) {
-				case int:
-					return l / float64(r), nil
-				case float64:
-					return l / r, nil
-				case string:
-					return nil, fmt.Errorf("Cannot divide string")
-				}
-			case string:
-				return nil, fmt.Errorf("Cannot divide string")
-			}
-		case ArithmeticMultiplication:
-			switch l := left.(type) {
-			case int:
-				switch r := right.(type) {
-				case int:
-					return l * r, nil
-				case float64:
-					return float64(l) * r, nil
-				case string:
-					return nil, fmt.Errorf("Cannot multiply string")
-				}
-			case float64:
-				switch r := right.(type) {
-				case int:
-					return l * float64(r), nil
-				case float64:
-					return l * r, nil
-				case string:
-					return nil, fmt.Errorf("Cannot multiply string")
-				}
-			case string:
-				return nil, fmt.Errorf("Cannot multiply string")
+			return Value{}, err
+		}
+		return value, i.checkString(i.ip, value)
+	case ConditionalExpression:
+		if e.Left == nil {
+			right, err := i.evaluateExpression(e.Right)
+			if err != nil {
+				return Value{}, err
 			}
+			return compare(e.Operator, Value{}, right)
+		}
+
+		left, right, err := i.evaluateOperands(e.Left, e.Right)
+		if err != nil {
+			return Value{}, err
 		}
+		return compare(e.Operator, left, right)
 	case Variable:
-		// TODO implement read variable
-		if value, ok := i.symbols[e.Name]; ok {
-			return value, nil
+		if e.Indexes != nil {
+			return i.element(e)
 		}
-		return nil, fmt.Errorf("No variable named %s", e.Name)
+		value, ok := i.lookup(e.Name)
+		if !ok {
+			return Value{}, runtimeErrorf(ErrorName, "No variable named %s", e.Name)
+		}
+		if value.Type() == ValueArray {
+			return Value{}, runtimeErrorf(ErrorType, "array %s needs an index", e.Name)
+		}
+		return value, nil
+	case CallExpression:
+		return i.callFunction(e)
+	case ParenthesizedExpression:
+		return i.evaluateExpression(e.Expression)
 	case IntegerNumber:
-		return strconv.Atoi(e.Value)
+		n, err := strconv.Atoi(e.Value)
+		return IntegerValue(n), err
 	case RealNumber:
-		return parseRealNumber(e.Value)
+		x, err := parseRealNumber(e.Value)
+		return RealValue(x), err
 	case String:
-		return strings.ReplaceAll(e.Value, "\"", ""), nil
+		return StringValue(strings.ReplaceAll(e.Value, "\"", "")), nil
 	}
 
-	return nil, nil
+	return Value{}, fmt.Errorf("unable to evaluate %T", node)
+}
+
+// evaluateOperands evaluates the operands of an operator, left first.
+func (i *interpreter) evaluateOperands(left, right Node) (Value, Value, error) {
+	l, err := i.evaluateExpression(left)
+	if err != nil {
+		return Value{}, Value{}, err
+	}
+	r, err := i.evaluateExpression(right)
+	if err != nil {
+		return Value{}, Value{}, err
+	}
+	return l, r, nil
 }
 
 func parseRealNumber(value string) (float64, error) {
@@ -175,326 +301,753 @@ func parseRealNumber(value string) (float64, error) {
 }
 
 func (i *interpreter) interpretAssignment(a AssignmentStatement) error {
-	value, err := i.evalauteArithmeticExpression(a.Variable.Type, a.ArithmeticExpression)
+	value, err := i.evaluateExpression(a.ArithmeticExpression)
 	if err != nil {
 		return err
 	}
 
-	switch value.(type) {
-	case int:
-		if a.Variable.Type != VariableInteger {
-			return fmt.Errorf("invalid assignment to variable type integer")
-		}
-	case float64:
-		if a.Variable.Type != VariableReal {
-			return fmt.Errorf("invalid assignment to variable type real")
-		}
-	case string:
-		if a.Variable.Type != VariableString {
-			return fmt.Errorf("invalid assignment to variable type string")
+	if err := assignable(a.Variable, value); err != nil {
+		return err
+	}
+
+	return i.store(a.Variable, value)
+}
+
+// assignable checks a value can be assigned to a variable of its type.
+func assignable(v Variable, value Value) error {
+	switch value.Type() {
+	case valueType(v.Type):
+		return nil
+	case ValueInteger, ValueReal, ValueString:
+		return runtimeErrorf(ErrorType, "invalid assignment to variable type %s", value.Type())
+	case ValueBoolean:
+		return runtimeErrorf(ErrorType, "invalid assignment of a condition to variable %s", v.Name)
+	}
+	return runtimeErrorf(ErrorType, "invalid assignment of %s to variable %s", value.Type(), v.Name)
+}
+
+// store sets a variable in the current scope, or the element of an array
+// when the variable has indexes.
+func (i *interpreter) store(v Variable, value Value) error {
+	if v.Indexes == nil {
+		if i.scope()[v.Name].Type() == ValueArray {
+			return runtimeErrorf(ErrorType, "array %s needs an index", v.Name)
 		}
+		return i.set(v.Name, value)
+	}
+
+	array, offset, err := i.locate(v)
+	if err != nil {
+		return err
 	}
+	array.Elements[offset] = value
 
-	i.symbols[a.Variable.Name] = value
 	return nil
 }
 
-func (i *interpreter) evaluateExpression(expr interface{}) (interface{}, error) {
-	switch e := expr.(type) {
-	case ConditionalExpression:
-		left, err := i.evaluateExpression(e.Left)
+// element returns the value of an array element.
+func (i *interpreter) element(v Variable) (Value, error) {
+	array, offset, err := i.locate(v)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return array.Elements[offset], nil
+}
+
+// locate finds the array of an indexed variable and the position of the
+// element in it, checking every index against the bounds of its dimension.
+func (i *interpreter) locate(v Variable) (*Array, int, error) {
+	value, _ := i.lookup(v.Name)
+	array, err := asArray(v.Name, value, len(v.Indexes))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := 0
+	for dimension, node := range v.Indexes {
+		value, err := i.evaluateExpression(node)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
-		right, err := i.evaluateExpression(e.Right)
+
+		index, err := array.index(v.Name, dimension, value)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
+		offset = offset*(array.Bounds[dimension]+1) + index
+	}
 
-		switch e.Operator {
-		case Not:
-			r, ok := right.(bool)
-			if !ok {
-				return nil, fmt.Errorf("unable to convert expression to boolean")
-			}
-			return !r, nil
-		case And:
-			l, lok := left.(bool)
-			r, rok := right.(bool)
-			if !(lok && rok) {
-				return nil, fmt.Errorf("unable to convert expression to boolean")
-			}
-			return l && r, nil
-		case Or:
-			l, lok := left.(bool)
-			r, rok := right.(bool)
-			if !(lok && rok) {
-				return nil, fmt.Errorf("unable to convert expression to boolean")
-			}
-			return l || r, nil
-		case LessThan:
-			switch l := left.(type) {
-			case int:
-				switch r := right.(type) {
-				case int:
-					return l < r, nil
-				case float64:
-					return float64(l) < r, nil
-				case string:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				}
-			case float64:
-				switch r := right.(type) {
-				case int:
-					return l < float64(r), nil
-				case float64:
-					return l < r, nil
-				case string:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				}
-			case string:
-				switch r := right.(type) {
-				case int:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				case float64:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				case string:
-					return l < r, nil
-				}
-			}
-		case LessThanEqual:
-			switch l := left.(type) {
-			case int:
-				switch r := right.(type) {
-				case int:
-					return l <= r, nil
-				case float64:
-					return float64(l) <= r, nil
-				case string:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				}
-			case float64:
-				switch r := right.(type) {
-				case int:
-					return l <= float64(r), nil
-				case float64:
-					return l <= r, nil
-				case string:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				}
-			case string:
-				switch r := right.(type) {
-				case int:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				case float64:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				case string:
-					return l <= r, nil
-				}
-			}
-		case Equal:
-			switch l := left.(type) {
-			case int:
-				switch r := right.(type) {
-				case int:
-					return l == r, nil
-				case float64:
-					return float64(l) == r, nil
-				case string:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				}
-			case float64:
-				switch r := right.(type) {
-				case int:
-					return l == float64(r), nil
-				case float64:
-					return l == r, nil
-				case string:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				}
-			case string:
-				switch r := right.(type) {
-				case int:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				case float64:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				case string:
-					return l == r, nil
-				}
-			}
-		case GreaterThan:
-			switch l := left.(type) {
-			case int:
-				switch r := right.(type) {
-				case int:
-					return l > r, nil
-				case float64:
-					return float64(l) > r, nil
-				case string:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				}
-			case float64:
-				switch r := right.(type) {
-				case int:
-					return l > float64(r), nil
-				case float64:
-					return l > r, nil
-				case string:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				}
-			case string:
-				switch r := right.(type) {
-				case int:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				case float64:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				case string:
-					return l > r, nil
-
-				}
-			}
-		case GreaterThanEqual:
-			switch l := left.(type) {
-			case int:
-				switch r := right.(type) {
-				case int:
-					return l >= r, nil
-				case float64:
-					return float64(l) >= r, nil
-				case string:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				}
-			case float64:
-				switch r := right.(type) {
-				case int:
-					return l >= float64(r), nil
-				case float64:
-					return l >= r, nil
-				case string:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				}
-			case string:
-				switch r := right.(type) {
-				case int:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				case float64:
-					return nil, fmt.Errorf("cannot compare integer and string")
-				case string:
-					return l >= r, nil
-
-				}
-			}
-		}
-	case Variable:
-		return i.symbols[e.Name], nil
-	case IntegerNumber:
-		return strconv.Atoi(e.Value)
-	case RealNumber:
-		return parseRealNumber(e.Value)
-	case String:
-		return strings.ReplaceAll(e.Value, "\"", ""), nil
+	return array, offset, nil
+}
+
+// asArray checks the value of the variable named name is an array with as
+// many dimensions as there are indexes.
+func asArray(name string, value Value, indexes int) (*Array, error) {
+	if value.Type() != ValueArray {
+		return nil, runtimeErrorf(ErrorName, "%s is not an array, declare it with dim", name)
 	}
 
-	return false, nil
+	array := value.Array()
+	if indexes != len(array.Bounds) {
+		return nil, runtimeErrorf(
+			ErrorRange,
+			"array %s has %d dimensions but got %d indexes",
+			name,
+			len(array.Bounds),
+			indexes,
+		)
+	}
+
+	return array, nil
 }
 
-func (i *interpreter) interpretIf(ifStatement IfStatement) error {
+// index checks a value is an integer within the bounds of a dimension of
+// the array named name.
+func (a *Array) index(name string, dimension int, value Value) (int, error) {
+	if value.Type() != ValueInteger {
+		return 0, runtimeErrorf(ErrorType, "index of array %s must be an integer", name)
+	}
+
+	index, bound := value.Integer(), a.Bounds[dimension]
+	if index < 0 || index > bound {
+		return 0, runtimeErrorf(
+			ErrorRange,
+			"index %d of array %s is out of range 0 to %d",
+			index,
+			name,
+			bound,
+		)
+	}
+
+	return index, nil
+}
+
+func (i *interpreter) interpretDim(d DimStatement) error {
+	name := d.Variable.Name
+	if _, ok := i.scope()[name]; ok {
+		return runtimeErrorf(ErrorName, "%s is already defined", name)
+	}
+
+	bounds := make([]int, len(d.Bounds))
+	for index, node := range d.Bounds {
+		value, err := i.evaluateExpression(node)
+		if err != nil {
+			return err
+		}
 
-	condition, err := i.evaluateExpression(ifStatement.Condition)
+		if bounds[index], err = arrayBound(name, value); err != nil {
+			return err
+		}
+	}
+
+	if err := i.allocate(i.ip, name, bounds); err != nil {
+		return err
+	}
+
+	created, err := newArray(name, d.Variable.Type, bounds)
 	if err != nil {
 		return err
 	}
 
-	cond, ok := condition.(bool)
+	array := ArrayValue(created)
+	if err := i.set(name, array); err != nil {
+		i.release(array)
+		return err
+	}
+	return nil
+}
+
+// arrayBound checks a value can be the bound of a dimension of the array
+// named name.
+func arrayBound(name string, value Value) (int, error) {
+	if value.Type() != ValueInteger || value.Integer() < 0 {
+		return 0, runtimeErrorf(ErrorArgument, "bound of array %s must be a positive integer", name)
+	}
+	return value.Integer(), nil
+}
+
+// maxArraySize is the most elements an array can have, so a dim cannot
+// take all the memory there is.
+const maxArraySize = 1 << 24
+
+// arraySize returns the number of elements of an array with the bounds, and
+// whether it is at most max. Bounds are checked before they are added to and
+// multiplied, so huge bounds cannot overflow.
+func arraySize(bounds []int, max int) (int, bool) {
+	size := 1
+	for _, bound := range bounds {
+		if bound >= max/size {
+			return 0, false
+		}
+		size *= bound + 1
+	}
+	return size, true
+}
+
+// newArray creates the array named name with every element set to the zero
+// value of its type.
+func newArray(name string, t VariableType, bounds []int) (*Array, error) {
+	size, ok := arraySize(bounds, maxArraySize)
 	if !ok {
-		return fmt.Errorf("Invalid condition")
+		return nil, runtimeErrorf(ErrorArgument, "array %s has more than %d elements", name, maxArraySize)
+	}
+
+	var zero Value
+	switch t {
+	case VariableInteger:
+		zero = IntegerValue(0)
+	case VariableReal:
+		zero = RealValue(0)
+	case VariableString:
+		zero = StringValue("")
+	}
+
+	elements := make([]Value, size)
+	for index := range elements {
+		elements[index] = zero
+	}
+
+	return &Array{
+		Type:     t,
+		Bounds:   bounds,
+		Elements: elements,
+	}, nil
+}
+
+func (i *interpreter) interpretIf(ifStatement IfStatement) error {
+
+	cond, err := i.evaluateCondition(ifStatement.Condition)
+	if err != nil {
+		return err
 	}
 	if cond {
-		return i.evaluateStatement(ifStatement.ThenStatement)
+		return i.executeBlock(ifStatement.Then)
 	}
 
-	return nil
+	for _, clause := range ifStatement.ElseIfs {
+		cond, err := i.evaluateCondition(clause.Condition)
+		if err != nil {
+			return err
+		}
+		if cond {
+			return i.executeBlock(clause.Body)
+		}
+	}
+
+	return i.executeBlock(ifStatement.Else)
 }
 
 func (i *interpreter) interpretPrint(print PrintStatement) error {
-
+	var line string
 	switch a := print.Argumenent.(type) {
 	case Variable:
-		v, ok := i.symbols[a.Name]
+		v, ok := i.lookup(a.Name)
 		if !ok {
-			return fmt.Errorf("Unable to find variable  %s", a.Name)
+			return runtimeErrorf(ErrorName, "Unable to find variable  %s", a.Name)
 		}
 
-		switch a.Type {
-		case VariableInteger:
-			fmt.Fprintf(i.out, "%d\n", v)
-		case VariableString:
-			fmt.Fprintf(i.out, "%s\n", v)
-		case VariableReal:
-			fmt.Fprintf(i.out, "%.6f\n", v)
+		if a.Indexes != nil {
+			var err error
+			if v, err = i.element(a); err != nil {
+				return err
+			}
+		} else if v.Type() == ValueArray {
+			return runtimeErrorf(ErrorType, "array %s needs an index", a.Name)
 		}
+
+		line = v.String()
 	case String:
-		fmt.Fprintln(i.out, strings.ReplaceAll(a.Value, "\"", ""))
+		line = strings.ReplaceAll(a.Value, "\"", "")
 	case IntegerNumber:
-		fmt.Fprintln(i.out, a.Value)
+		line = a.Value
 	case RealNumber:
-		fmt.Fprintln(i.out, a.Value)
+		line = a.Value
+	case nil:
 	default:
-		fmt.Fprintln(i.out)
+		v, err := i.evaluateExpression(a)
+		if err != nil {
+			return err
+		}
+
+		line = v.String()
 	}
 
-	return nil
+	return i.write(i.out, i.ip, line)
 }
 
 func (i *interpreter) interpretRead(read ReadStatement) error {
+	value, err := readValue(i.in, read.Variable)
+	if err != nil {
+		return err
+	}
+
+	if err := i.checkString(i.ip, value); err != nil {
+		return err
+	}
+
+	return i.store(read.Variable, value)
+}
+
+// readValue reads a line of input and converts it to the type of a
+// variable.
+func readValue(in *bufio.Reader, v Variable) (Value, error) {
+	line, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return Value{}, err
+	}
+	line = strings.TrimSpace(line)
 
-	switch read.Variable.Type {
+	var value Value
+	switch v.Type {
 	case VariableInteger:
-		var temp int
-		if _, err := fmt.Scanf("%d\n", &temp); err != nil {
+		var n int
+		n, err = strconv.Atoi(line)
+		value = IntegerValue(n)
+	case VariableReal:
+		var x float64
+		x, err = strconv.ParseFloat(line, 64)
+		value = RealValue(x)
+	case VariableString:
+		value, err = StringValue(line), nil
+	}
+	if err != nil {
+		return Value{}, runtimeErrorf(
+			ErrorInput,
+			"unable to read %q into %s variable %s",
+			line,
+			v.Type,
+			v.Name,
+		)
+	}
+
+	return value, nil
+}
+
+// forValue converts a for statement bound to the type of its counter.
+func forValue(counter Variable, value Value) (Value, error) {
+	switch value.Type() {
+	case ValueInteger:
+		if counter.Type == VariableReal {
+			return RealValue(value.number()), nil
+		}
+		return value, nil
+	case ValueReal:
+		if counter.Type == VariableInteger {
+			return Value{}, runtimeErrorf(ErrorType, "for counter %s is integer but got real %f", counter.Name, value.Real())
+		}
+		return value, nil
+	}
+
+	return Value{}, runtimeErrorf(ErrorType, "for counter %s needs a number, got %s", counter.Name, value.Type())
+}
+
+func (i *interpreter) interpretFor(f ForStatement) error {
+	bounds := []Value{{}, {}, IntegerValue(1)}
+	for index, node := range []Node{f.From, f.To, f.Step} {
+		if node == nil {
+			continue
+		}
+
+		value, err := i.evaluateExpression(node)
+		if err != nil {
 			return err
 		}
-		i.symbols[read.Variable.Name] = temp
-	case VariableReal:
-		var temp float64
-		if _, err := fmt.Scanf("%f\n", &temp); err != nil {
+		bounds[index] = value
+	}
+
+	for index := range bounds {
+		value, err := forValue(f.Variable, bounds[index])
+		if err != nil {
 			return err
 		}
-		i.symbols[read.Variable.Name] = temp
-	case VariableString:
-		var temp string
-		if _, err := fmt.Scanf("%s\n", &temp); err != nil {
+		bounds[index] = value
+	}
+
+	from, to, step := bounds[0], bounds[1], bounds[2]
+
+	if step.number() == 0 {
+		return runtimeErrorf(ErrorArgument, "for counter %s has a step of zero", f.Variable.Name)
+	}
+
+	if err := i.set(f.Variable.Name, from); err != nil {
+		return err
+	}
+
+	variables := i.scope()
+	for {
+		if err := i.before(f, true); err != nil {
+			return err
+		}
+		if forFinished(variables[f.Variable.Name], to, step) {
+			return nil
+		}
+
+		if stop, err := i.executeLoopBody(f.Body); stop || err != nil {
 			return err
 		}
-		i.symbols[read.Variable.Name] = temp
+
+		variables[f.Variable.Name] = forStep(variables[f.Variable.Name], step)
 	}
+}
 
-	return nil
+// forFinished reports whether a for counter went past the bound it counts
+// to.
+func forFinished(counter, to, step Value) bool {
+	if !counter.isNumber() {
+		return true
+	}
+	if step.number() > 0 {
+		return counter.number() > to.number()
+	}
+	return counter.number() < to.number()
 }
 
-func (i *interpreter) interpretGoto(gotostatement GotoStatement) error {
-	i.jump = true
+// forStep adds the step to a for counter.
+func forStep(counter, step Value) Value {
+	switch counter.Type() {
+	case ValueInteger:
+		return IntegerValue(counter.Integer() + step.Integer())
+	case ValueReal:
+		return RealValue(counter.Real() + step.Real())
+	}
+	return counter
+}
+
+// executeLoopBody runs one iteration of a loop and reports whether the
+// loop must stop because of an exit or a jump.
+func (i *interpreter) executeLoopBody(body []Node) (bool, error) {
+	err := i.executeBlock(body)
+	if err == errExit {
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+
+	return i.jump, nil
+}
+
+func (i *interpreter) evaluateCondition(condition Node) (bool, error) {
+	value, err := i.evaluateExpression(condition)
+	if err != nil {
+		return false, err
+	}
+
+	if value.Type() != ValueBoolean {
+		return false, runtimeErrorf(ErrorType, "Invalid condition")
+	}
+
+	return value.Boolean(), nil
+}
+
+// testLoop evaluates the condition of a loop, counting its test as a step.
+func (i *interpreter) testLoop(loop, condition Node) (bool, error) {
+	if err := i.before(loop, true); err != nil {
+		return false, err
+	}
+	return i.evaluateCondition(condition)
+}
+
+// before counts a step before a statement runs, or before a loop tests its
+// condition again, and stops in the debugger.
+func (i *interpreter) before(statement Node, test bool) error {
+	if err := i.step(i.ip); err != nil {
+		return err
+	}
 
+	if i.debugger == nil || !pausesAt(statement, test) {
+		return nil
+	}
+	return i.debugger.Before(&walkerPause{stopAt: stopAt{statement, test}, i: i})
+}
+
+func (i *interpreter) interpretWhile(w WhileStatement) error {
+	for {
+		cond, err := i.testLoop(w, w.Condition)
+		if err != nil || !cond {
+			return err
+		}
+
+		if stop, err := i.executeLoopBody(w.Body); stop || err != nil {
+			return err
+		}
+	}
+}
+
+func (i *interpreter) interpretDo(d DoStatement) error {
+	for {
+		if stop, err := i.executeLoopBody(d.Body); stop || err != nil {
+			return err
+		}
+
+		cond, err := i.testLoop(d, d.Condition)
+		if err != nil || cond {
+			return err
+		}
+	}
+}
+
+func (i *interpreter) interpretGoto(gotostatement GotoStatement) error {
 	address, ok := i.labels[gotostatement.Label]
 	if !ok {
-		return fmt.Errorf("Unable to to goto label %s doesn't exist", gotostatement.Label)
+		return runtimeErrorf(ErrorName, "Unable to to goto label %s doesn't exist", gotostatement.Label)
 	}
+	i.jump = true
 	i.jumpTo = address
 
 	return nil
 }
 
-func (i *interpreter) findLabels(statements []Node) error {
+func (i *interpreter) interpretGosub(gosub GosubStatement) error {
+	address, ok := i.labels[gosub.Label]
+	if !ok {
+		return runtimeErrorf(ErrorName, "Unable to to gosub label %s doesn't exist", gosub.Label)
+	}
+
+	if len(i.calls) >= i.maxCallDepth {
+		return runtimeErrorf(ErrorStackOverflow, "gosub %s exceeds the maximum call depth of %d", gosub.Label, i.maxCallDepth)
+	}
+
+	caller := i.ip
+	i.calls = append(i.calls, &frame{call: gosub, address: caller})
+
+	err := i.run(address)
+
+	i.calls = i.calls[:len(i.calls)-1]
+	i.ip = caller
+
+	if err == errReturn {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The subroutine ran off the end of the program
+	return io.EOF
+}
+
+func (i *interpreter) interpretReturn(r ReturnStatement) error {
+	if len(i.calls) == 0 {
+		return runtimeErrorf(ErrorControl, "return without gosub")
+	}
+
+	if r.Value != nil {
+		value, err := i.evaluateExpression(r.Value)
+		if err != nil {
+			return err
+		}
+		i.calls[len(i.calls)-1].result = value
+	}
+
+	return errReturn
+}
+
+// scope returns the variables assignments go to: the locals of the sub or
+// function being run, or the globals.
+func (i *interpreter) scope() map[string]Value {
+	if n := len(i.calls); n > 0 && i.calls[n-1].locals != nil {
+		return i.calls[n-1].locals
+	}
+	return i.symbols
+}
+
+// set assigns a variable in the current scope. New globals count against
+// the maximum number of variables.
+func (i *interpreter) set(name string, value Value) error {
+	if n := len(i.calls); n > 0 && i.calls[n-1].locals != nil {
+		i.calls[n-1].locals[name] = value
+		return nil
+	}
+
+	if _, ok := i.symbols[name]; !ok {
+		if err := i.define(i.ip, name); err != nil {
+			return err
+		}
+	}
+	i.symbols[name] = value
+	return nil
+}
+
+// lookup finds a variable in the current scope, falling back to the globals
+// inside subs and functions.
+func (i *interpreter) lookup(name string) (Value, bool) {
+	if value, ok := i.scope()[name]; ok {
+		return value, true
+	}
+
+	value, ok := i.symbols[name]
+	return value, ok
+}
+
+// call runs a sub or function with its arguments bound to its parameters
+// in a new frame.
+func (i *interpreter) call(call Node, name string, arguments []Node) (Value, error) {
+	proc, ok := i.procedures[name]
+	if !ok {
+		return Value{}, runtimeErrorf(ErrorName, "No sub or function named %s", name)
+	}
+
+	if err := proc.checkCount(len(arguments)); err != nil {
+		return Value{}, err
+	}
+
+	locals := make(map[string]Value, len(arguments))
+	for index, argument := range arguments {
+		value, err := i.evaluateExpression(argument)
+		if err != nil {
+			return Value{}, err
+		}
+
+		if err := proc.checkArgument(index, value); err != nil {
+			return Value{}, err
+		}
+		locals[proc.parameters[index].Name] = value
+	}
+
+	if len(i.calls) >= i.maxCallDepth {
+		return Value{}, runtimeErrorf(ErrorStackOverflow, "call to %s exceeds the maximum call depth of %d", name, i.maxCallDepth)
+	}
+
+	f := &frame{call: call, address: i.ip, locals: locals}
+	i.calls = append(i.calls, f)
+
+	err := i.executeBlock(proc.body)
+
+	i.calls = i.calls[:len(i.calls)-1]
+	for _, value := range locals {
+		i.release(value)
+	}
+
+	if err != nil && err != errReturn {
+		return Value{}, err
+	}
+
+	if !proc.function {
+		return Value{}, nil
+	}
+
+	if err := proc.checkResult(f.result); err != nil {
+		return Value{}, err
+	}
+
+	return f.result, nil
+}
+
+// checkCount checks a call gives as many arguments as the procedure has
+// parameters.
+func (p procedure) checkCount(count int) error {
+	if count != len(p.parameters) {
+		return runtimeErrorf(
+			ErrorArgument,
+			"%s takes %d arguments but got %d",
+			p.name,
+			len(p.parameters),
+			count,
+		)
+	}
+	return nil
+}
+
+// checkArgument checks the type of the value given for the parameter at
+// index.
+func (p procedure) checkArgument(index int, value Value) error {
+	parameter := p.parameters[index]
+	if t := value.Type(); t != valueType(parameter.Type) {
+		return runtimeErrorf(
+			ErrorType,
+			"argument %s of %s must be %s but got %s",
+			parameter.Name,
+			p.name,
+			parameter.Type,
+			t,
+		)
+	}
+	return nil
+}
+
+// checkResult checks a function returned a value of its type.
+func (p procedure) checkResult(result Value) error {
+	if result.Type() == ValueNone {
+		return runtimeErrorf(ErrorControl, "function %s ended without returning a value", p.name)
+	}
+	if t := result.Type(); t != valueType(p.returns) {
+		return runtimeErrorf(ErrorType, "function %s returns %s but got %s", p.name, p.returns, t)
+	}
+	return nil
+}
+
+func (i *interpreter) callFunction(c CallExpression) (Value, error) {
+	proc, ok := i.procedures[c.Name]
+	if ok && !proc.function {
+		return Value{}, runtimeErrorf(ErrorControl, "sub %s does not return a value", c.Name)
+	}
+
+	if b, isBuiltin := builtins[c.Name]; !ok && isBuiltin {
+		return i.callBuiltin(c, b)
+	}
+
+	// Arrays declared in an earlier input parse as calls
+	if value, _ := i.lookup(c.Name); !ok && value.Type() == ValueArray {
+		return i.element(Variable{
+			Name:    c.Name,
+			Type:    value.Array().Type,
+			Indexes: c.Arguments,
+			tokens:  c.tokens,
+		})
+	}
+
+	return i.call(c, c.Name, c.Arguments)
+}
+
+// findDeclarations records the address of every label and the subs and
+// functions declared in the statements.
+func (i *interpreter) findDeclarations(statements []Node, offset int) error {
 	for address, statement := range statements {
 		switch s := statement.(type) {
 		case LabelStatement:
-			i.labels[s.Name] = address
+			i.labels[s.Name] = offset + address
+		case SubStatement, FunctionStatement:
+			proc := declaration(s)
+			i.procedures[proc.name] = proc
 		}
 	}
 	return nil
 }
 
+// declaration returns the procedure declared by a sub or function
+// statement.
+func declaration(statement Node) procedure {
+	switch s := statement.(type) {
+	case SubStatement:
+		return procedure{
+			name:       s.Name,
+			parameters: s.Parameters,
+			body:       s.Body,
+		}
+	case FunctionStatement:
+		return procedure{
+			name:       s.Name,
+			parameters: s.Parameters,
+			body:       s.Body,
+			function:   true,
+			returns:    s.Type,
+		}
+	}
+	return procedure{}
+}
+
+// evaluateStatement runs a statement and gives the errors it returns their
+// position in the source.
 func (i *interpreter) evaluateStatement(statement Node) error {
+	if err := i.before(statement, false); err != nil {
+		return i.locateError(statement, err)
+	}
+	return i.locateError(statement, i.interpretStatement(statement))
+}
+
+func (i *interpreter) interpretStatement(statement Node) error {
 
 	switch s := statement.(type) {
 	case RemStatement:
@@ -512,18 +1065,78 @@ func (i *interpreter) evaluateStatement(statement Node) error {
 		return nil
 	case GotoStatement:
 		return i.interpretGoto(s)
+	case GosubStatement:
+		return i.interpretGosub(s)
+	case ReturnStatement:
+		return i.interpretReturn(s)
+	case SubStatement, FunctionStatement:
+		return nil
+	case CallStatement:
+		_, err := i.call(s, s.Name, s.Arguments)
+		return err
+	case ForStatement:
+		return i.interpretFor(s)
+	case WhileStatement:
+		return i.interpretWhile(s)
+	case DoStatement:
+		return i.interpretDo(s)
+	case ExitStatement:
+		return errExit
+	case DimStatement:
+		return i.interpretDim(s)
 	}
 	return nil
 }
 
+// executeBlock runs the statements of a block. It stops early when one of
+// them jumps, leaving the jump to the instruction loop in run.
+func (i *interpreter) executeBlock(statements []Node) error {
+	for _, statement := range statements {
+		if err := i.evaluateStatement(statement); err != nil {
+			return err
+		}
+
+		if i.jump {
+			return nil
+		}
+	}
+
+	return nil
+}
+
 func (i *interpreter) Execute(statements []Node) error {
+	return i.ExecuteContext(context.Background(), statements)
+}
+
+func (i *interpreter) ExecuteContext(ctx context.Context, statements []Node) error {
+	i.program = statements
+	i.labels = map[string]int{}
+	i.procedures = map[string]procedure{}
+
+	if err := i.findDeclarations(statements, 0); err != nil {
+		return err
+	}
+
+	i.start(ctx)
+	return i.run(0)
+}
+
+func (i *interpreter) Continue(statements []Node) error {
+	start := len(i.program)
+	i.program = append(i.program, statements...)
 
-	if err := i.findLabels(statements); err != nil {
+	if err := i.findDeclarations(statements, start); err != nil {
 		return err
 	}
 
-	for ip := 0; ip < len(statements); ip++ {
-		statement := statements[ip]
+	i.start(context.Background())
+	return i.run(start)
+}
+
+func (i *interpreter) run(start int) error {
+	for ip := start; ip < len(i.program); ip++ {
+		i.ip = ip
+		statement := i.program[ip]
 		err := i.evaluateStatement(statement)
 		if err != nil {
 			return err
@@ -538,3 +1151,36 @@ func (i *interpreter) Execute(statements []Node) error {
 
 	return nil
 }
+
+func (i *interpreter) Variables() map[string]Value {
+	variables := make(map[string]Value, len(i.symbols))
+	for name, value := range i.symbols {
+		variables[name] = copyValue(value)
+	}
+	return variables
+}
+
+// copyValue copies arrays, so changing the copy leaves the variable as it
+// is.
+func copyValue(value Value) Value {
+	if value.Type() == ValueArray {
+		array := value.Array()
+		return ArrayValue(&Array{
+			Type:     array.Type,
+			Bounds:   append([]int{}, array.Bounds...),
+			Elements: append([]Value{}, array.Elements...),
+		})
+	}
+	return value
+}
+
+func (i *interpreter) Reset() {
+	i.symbols = map[string]Value{}
+	i.labels = map[string]int{}
+	i.procedures = map[string]procedure{}
+	i.program = nil
+	i.jump = false
+	i.jumpTo = 0
+	i.calls = nil
+	i.budget = budget{max: i.limits}
+}
@@ -0,0 +1,592 @@
+package lao_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+// engines are the ways an interpreter can run a program, which must give the
+// same results.
+var engines = []struct {
+	name    string
+	options []lao.InterpreterOption
+}{
+	{name: "vm"},
+	{name: "tree-walker", options: []lao.InterpreterOption{lao.WithTreeWalker()}},
+}
+
+func TestInterpreter(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		program  string
+		input    string
+		expected string
+		err      string
+	}{
+		{
+			desc:     "read integer",
+			program:  "read a\nprint a",
+			input:    "42\n",
+			expected: "42\n",
+		},
+		{
+			desc:     "read real and string",
+			program:  "read gx\nread w\nprint gx\nprint w",
+			input:    "2.5\nhello world\n",
+			expected: "2.500000\nhello world\n",
+		},
+		{
+			desc:     "read last line without newline",
+			program:  "read b\nprint b",
+			input:    "7",
+			expected: "7\n",
+		},
+		{
+			desc:     "for loop",
+			program:  "for c = 1 to 10 step 4\nprint c\nnext c\nprint c",
+			expected: "1\n5\n9\n13\n",
+		},
+		{
+			desc:     "for loop with negative real step",
+			program:  "for gx = 1 to 0 step -0.5\nprint gx\nnext",
+			expected: "1.000000\n0.500000\n0.000000\n",
+		},
+		{
+			desc:     "for loop that never runs",
+			program:  "for c = 5 to 1\nprint c\nnext\nprint \"done\"",
+			expected: "done\n",
+		},
+		{
+			desc:     "goto out of a for loop",
+			program:  "for c = 1 to 10\nif c .eq. 3 then goto out\nprint c\nnext\nout:\nprint \"out\"",
+			expected: "1\n2\nout\n",
+		},
+		{
+			desc:     "while loop",
+			program:  "c = 1\nwhile c .lt. 4\nprint c\nc = c .add. 1\nwend",
+			expected: "1\n2\n3\n",
+		},
+		{
+			desc:     "do loop runs at least once",
+			program:  "c = 10\ndo\nprint c\nc = c .add. 1\nloop until c .gt. 5",
+			expected: "10\n",
+		},
+		{
+			desc:     "exit leaves the innermost loop",
+			program:  "for c = 1 to 2\nd = 0\nwhile d .lt. 10\nd = d .add. 1\nif d .eq. 2 then exit\nwend\nprint d\nnext",
+			expected: "2\n2\n",
+		},
+		{
+			desc:     "goto a label named like a keyword",
+			program:  "c = 0\nloop:\nc = c .add. 1\nif c .lt. 3 then goto loop\nprint c",
+			expected: "3\n",
+		},
+		{
+			desc:     "gosub returns after the call",
+			program:  "gosub greet\nfor c = 1 to 2\ngosub greet\nnext\nend.\ngreet:\nprint \"hi\"\nreturn",
+			expected: "hi\nhi\nhi\n",
+		},
+		{
+			desc:     "nested gosub",
+			program:  "gosub outer\nprint \"done\"\nend.\nouter:\ngosub inner\nprint \"outer\"\nreturn\ninner:\nprint \"inner\"\nreturn",
+			expected: "inner\nouter\ndone\n",
+		},
+		{
+			desc:    "return without gosub",
+			program: "print \"a\"\nreturn",
+			err:     "return without gosub at line 2 column 1",
+		},
+		{
+			desc:    "gosub recursion too deep",
+			program: "again:\ngosub again",
+			err:     "gosub again exceeds the maximum call depth of 1000 at line 2 column 1",
+		},
+		{
+			desc:     "recursive function",
+			program:  "a = fact(5)\nprint a\nfunction fact(c)\nif c .le. 1 then return 1\nreturn c .mul. fact(c .sub. 1)\nend function",
+			expected: "120\n",
+		},
+		{
+			desc:     "function result in expressions",
+			program:  "a = double(4) .add. 1\nprint a\nif double(2) .eq. 4 then print \"four\"\nfunction double(c)\nreturn c .mul. 2\nend function",
+			expected: "9\nfour\n",
+		},
+		{
+			desc:     "sub locals do not overwrite globals",
+			program:  "w = \"global\"\nshow(\"arg\", 2)\nprint w\nsub show(w, gx as integer)\nprint w\nprint gx\nz = \"local\"\nend sub",
+			expected: "arg\n2\nglobal\n",
+		},
+		{
+			desc:     "function with explicit return type",
+			program:  "gx = half(3)\nprint gx\nfunction half(c) as real\nreturn c .div. 2.0\nend function",
+			expected: "1.500000\n",
+		},
+		{
+			desc:    "argument of the wrong type",
+			program: "show(1)\nsub show(w)\nprint w\nend sub",
+			err:     "argument w of show must be string but got integer at line 1 column 1",
+		},
+		{
+			desc:    "wrong number of arguments",
+			program: "show(\"a\", \"b\")\nsub show(w)\nprint w\nend sub",
+			err:     "show takes 1 arguments but got 2 at line 1 column 1",
+		},
+		{
+			desc:    "sub used as a value",
+			program: "a = show()\nsub show()\nprint\nend sub",
+			err:     "sub show does not return a value at line 1 column 1",
+		},
+		{
+			desc:     "block if branches",
+			program:  "for c = 1 to 4\nif c .eq. 1 then\nprint \"one\"\nelseif c .eq. 2 then\nprint \"two\"\nprint c\nelseif c .eq. 3 then\nprint \"three\"\nelse\nprint \"other\"\nendif\nnext",
+			expected: "one\ntwo\n2\nthree\nother\n",
+		},
+		{
+			desc:     "block if with end if and no else",
+			program:  "c = 0\nif c .eq. 1 then\nprint \"one\"\nend if\nprint \"after\"",
+			expected: "after\n",
+		},
+		{
+			desc:     "single line if with not after and",
+			program:  "b = 3\nif .not. b .gt. 7 .and. .not. b .le. 9 .or. .not. b .eq. 8 then print \"yes\"",
+			expected: "yes\n",
+		},
+		{
+			desc:     "parentheses change precedence",
+			program:  "a = 2\nc = (a .add. 3) .mul. 4\nprint c\nprint a .add. 3 .mul. 4",
+			expected: "20\n14\n",
+		},
+		{
+			desc:     "unary minus",
+			program:  "a = 2\nprint -a .mul. 3\nprint -(a .sub. 5)\nprint 1 .sub. -a",
+			expected: "-6\n3\n3\n",
+		},
+		{
+			desc:     "arithmetic inside relational operands",
+			program:  "a = 5\nif a .add. 1 .gt. 5 .and. (a .mul. 2 .eq. 10) then print \"yes\"",
+			expected: "yes\n",
+		},
+		{
+			desc:     "operators without spaces",
+			program:  "gx = (1.5).mul.2\nprint gx",
+			expected: "3.000000\n",
+		},
+		{
+			desc:    "condition assigned to a variable",
+			program: "a = 1 .gt. 0",
+			err:     "invalid assignment of a condition to variable a at line 1 column 1",
+		},
+		{
+			desc:    "for loop with zero step",
+			program: "for c = 1 to 10 step 0\nnext",
+			err:     "for counter c has a step of zero at line 1 column 1",
+		},
+		{
+			desc:    "integer for counter with real bound",
+			program: "for c = 1 to 2.5\nnext",
+			err:     "for counter c is integer but got real 2.500000 at line 1 column 1",
+		},
+		{
+			desc:    "read invalid integer",
+			program: "read a",
+			input:   "abc\n",
+			err:     `unable to read "abc" into integer variable a at line 1 column 1`,
+		},
+		{
+			desc:     "array elements",
+			program:  "dim a(10)\nfor c = 0 to 10\na(c) = c .mul. c\nnext c\na(3) = a(3) .add. 1\nprint a(3)\nprint a(10)",
+			expected: "10\n100\n",
+		},
+		{
+			desc:     "two dimensional array",
+			program:  "dim gm(3, 3)\ngm(1, 2) = 0.5\ngm(2, 1) = gm(1, 2) .mul. 3\nprint gm(2, 1)\nprint gm(0, 0)",
+			expected: "1.500000\n0.000000\n",
+		},
+		{
+			desc:     "read into string array",
+			program:  "dim w(1)\nread w(1)\nprint w(1)",
+			input:    "hello\n",
+			expected: "hello\n",
+		},
+		{
+			desc:    "array index out of range",
+			program: "dim a(2)\nprint \"ok\"\na(3) = 1",
+			err:     "index 3 of array a is out of range 0 to 2 at line 3 column 1",
+		},
+		{
+			desc:    "array element of the wrong type",
+			program: "dim a(2)\na(1) = \"x\"",
+			err:     "invalid assignment to variable type string at line 2 column 1",
+		},
+		{
+			desc:    "array without index",
+			program: "dim a(2)\nprint a",
+			err:     "array a needs an index at line 2 column 1",
+		},
+		{
+			desc:    "array too large",
+			program: "dim a(9223372036854775807)",
+			err:     "array a has more than 16777216 elements at line 1 column 1",
+		},
+		{
+			desc:    "array with too many elements",
+			program: "dim a(100000, 100000, 100000, 100000)",
+			err:     "array a has more than 16777216 elements at line 1 column 1",
+		},
+		{
+			desc:    "undefined variable in a condition",
+			program: "if a .eq. 1 then print \"one\"",
+			err:     "No variable named a at line 1 column 1",
+		},
+		{
+			desc:    "condition in arithmetic",
+			program: "a = (1 .lt. 2) .add. 1",
+			err:     "cannot add boolean and integer at line 1 column 1",
+		},
+		{
+			desc:     "not equal",
+			program:  "a = 1\nif a .ne. 2 then print \"ne\"\nif a .ne. 1 then print \"eq\"",
+			expected: "ne\n",
+		},
+		{
+			desc:     "not equal strings and mixed numbers",
+			program:  "w = \"a\"\nif w .ne. \"b\" then print \"strings\"\nif 1 .ne. 1.0 then print \"mixed\"",
+			expected: "strings\n",
+		},
+		{
+			desc:    "conditions cannot be compared",
+			program: "if (1 .lt. 2) .eq. (2 .lt. 3) then print \"same\"",
+			err:     "cannot compare boolean and boolean at line 1 column 1",
+		},
+		{
+			desc:     "string concatenation",
+			program:  "w = \"n=\" .add. 2\nprint w",
+			expected: "n=2\n",
+		},
+	}
+	for _, engine := range engines {
+		for _, tC := range testCases {
+			t.Run(engine.name+"/"+tC.desc, func(t *testing.T) {
+				statements, err := lao.NewParser(
+					lao.NewTokenizer(strings.NewReader(tC.program)),
+				).Parse()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				out := new(bytes.Buffer)
+				interpreter := lao.NewInterpreter(
+					out,
+					append(engine.options, lao.WithInput(strings.NewReader(tC.input)))...,
+				)
+
+				err = interpreter.Execute(statements)
+				if err == io.EOF {
+					err = nil
+				}
+				if tC.err != "" {
+					assert.EqualError(t, err, tC.err)
+					return
+				}
+
+				assert.NoError(t, err)
+				assert.Equal(t, tC.expected, out.String())
+			})
+		}
+	}
+}
+
+func TestInterpreterContinue(t *testing.T) {
+	inputs := []struct {
+		program  string
+		expected string
+		err      string
+	}{
+		{program: "dim gm(2)\nc = 1\nagain:\nprint c", expected: "1\n"},
+		{program: "goto later", err: "Unable to to goto label later doesn't exist at line 1 column 1"},
+		{program: "gm(1) = 2.5\nprint gm(1)\nlater:", expected: "2.500000\n"},
+		{program: "function double(a)\nreturn a .mul. 2\nend function", expected: ""},
+		{program: "c = double(c)\nif c .lt. 3 then goto again", expected: "2\n"},
+	}
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			interpreter := lao.NewInterpreter(out, engine.options...)
+
+			for _, input := range inputs {
+				statements, err := lao.NewParser(
+					lao.NewTokenizer(strings.NewReader(input.program)),
+				).Parse()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				out.Reset()
+				err = interpreter.Continue(statements)
+				if input.err != "" {
+					assert.EqualError(t, err, input.err)
+					continue
+				}
+
+				assert.NoError(t, err)
+				assert.Equal(t, input.expected, out.String())
+			}
+
+			variables := interpreter.Variables()
+			assert.Equal(t, lao.IntegerValue(4), variables["c"])
+			assert.Equal(t, []lao.Value{lao.RealValue(0), lao.RealValue(2.5), lao.RealValue(0)}, variables["gm"].Array().Elements)
+
+			interpreter.Reset()
+			assert.Empty(t, interpreter.Variables())
+		})
+	}
+}
+
+func TestInterpreterLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := []struct {
+		desc     string
+		program  string
+		input    string
+		ctx      context.Context
+		option   lao.InterpreterOption
+		limit    lao.Limit
+		line     int
+		expected string
+		err      string
+	}{
+		{
+			desc:    "loop within the steps",
+			program: "for c = 1 to 3\nnext c",
+			option:  lao.WithMaxSteps(5),
+		},
+		{
+			desc:    "loop tests are steps",
+			program: "for c = 1 to 3\nnext c",
+			option:  lao.WithMaxSteps(4),
+			limit:   lao.LimitSteps,
+			line:    1,
+		},
+		{
+			desc:    "goto loop",
+			program: "again:\ngoto again",
+			option:  lao.WithMaxSteps(100),
+			limit:   lao.LimitSteps,
+			line:    2,
+			err:     "exceeded the limit of 100 steps (instruction 1) at line 2 column 1",
+		},
+		{
+			desc:    "cancelled context",
+			program: "while 1 .eq. 1\nwend",
+			ctx:     cancelled,
+			limit:   lao.LimitContext,
+			line:    1,
+			err:     "stopped: context canceled (instruction 0) at line 1 column 1",
+		},
+		{
+			desc:     "output",
+			program:  "print \"hello\"\nprint \"world\"",
+			option:   lao.WithMaxOutput(10),
+			limit:    lao.LimitOutput,
+			line:     2,
+			expected: "hello\n",
+		},
+		{
+			desc:    "joined strings",
+			program: "w = \"ab\"\nfor c = 1 to 10\nw = w .add. w\nnext c",
+			option:  lao.WithMaxStringLength(100),
+			limit:   lao.LimitStringLength,
+			line:    3,
+		},
+		{
+			desc:    "string read",
+			program: "read w",
+			input:   "a long line\n",
+			option:  lao.WithMaxStringLength(5),
+			limit:   lao.LimitStringLength,
+			line:    1,
+		},
+		{
+			desc:    "string from a built-in function",
+			program: "w = ucase(\"abcdef\")",
+			option:  lao.WithMaxStringLength(5),
+			limit:   lao.LimitStringLength,
+			line:    1,
+		},
+		{
+			desc:    "variables",
+			program: "a = 1\nb = 2\na = 3\nc = 4",
+			option:  lao.WithMaxVariables(2),
+			limit:   lao.LimitVariables,
+			line:    4,
+		},
+		{
+			desc:    "variables of subs are not counted",
+			program: "show()\na = 1\nsub show()\nb = 1\nc = 2\nend sub",
+			option:  lao.WithMaxVariables(1),
+		},
+		{
+			desc:    "array elements",
+			program: "dim a(4)\ndim b(4)",
+			option:  lao.WithMaxArrayElements(9),
+			limit:   lao.LimitArrayElements,
+			line:    2,
+		},
+		{
+			desc:    "arrays of subs are released",
+			program: "for c = 1 to 3\nfill()\nnext c\nsub fill()\ndim a(4)\nend sub",
+			option:  lao.WithMaxArrayElements(5),
+		},
+		{
+			desc:    "huge arrays",
+			program: "dim a(100000000, 100000000, 100000000)",
+			option:  lao.WithMaxArrayElements(1000),
+			limit:   lao.LimitArrayElements,
+			line:    1,
+		},
+		{
+			desc:    "arrays overflowing a high limit",
+			program: "dim a(3037000499, 3037000499)",
+			option:  lao.WithMaxArrayElements(math.MaxInt64),
+			limit:   lao.LimitArrayElements,
+			line:    1,
+		},
+	}
+	for _, engine := range engines {
+		for _, tC := range testCases {
+			t.Run(engine.name+"/"+tC.desc, func(t *testing.T) {
+				statements, err := lao.NewParser(
+					lao.NewTokenizer(strings.NewReader(tC.program)),
+				).Parse()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ctx := tC.ctx
+				if ctx == nil {
+					ctx = context.Background()
+				}
+
+				options := append(engine.options, lao.WithInput(strings.NewReader(tC.input)))
+				if tC.option != nil {
+					options = append(options, tC.option)
+				}
+
+				out := new(bytes.Buffer)
+				interpreter := lao.NewInterpreter(out, options...)
+				err = interpreter.ExecuteContext(ctx, statements)
+				assert.Equal(t, tC.expected, out.String())
+				if tC.limit == 0 {
+					assert.NoError(t, err)
+					return
+				}
+
+				var runtimeError *lao.RuntimeError
+				if assert.True(t, errors.As(err, &runtimeError)) {
+					assert.Equal(t, lao.ErrorLimit, runtimeError.Kind)
+					assert.Equal(t, tC.line, runtimeError.Line)
+				}
+
+				var limitError *lao.LimitError
+				if assert.True(t, errors.As(err, &limitError)) {
+					assert.Equal(t, tC.limit, limitError.Limit)
+				}
+
+				if tC.err != "" {
+					assert.EqualError(t, err, tC.err)
+				}
+			})
+		}
+	}
+}
+
+func TestInterpreterDeadline(t *testing.T) {
+	statements, err := lao.NewParser(
+		lao.NewTokenizer(strings.NewReader("a = 0\nagain:\na = a .add. 1\ngoto again")),
+	).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			interpreter := lao.NewInterpreter(ioutil.Discard, engine.options...)
+			err := interpreter.ExecuteContext(ctx, statements)
+			assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		})
+	}
+}
+
+func TestInterpreterExitOutsideLoop(t *testing.T) {
+	// The parser rejects these, but an unmarshalled program may have them
+	statements := []lao.Node{lao.ExitStatement{}}
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			interpreter := lao.NewInterpreter(ioutil.Discard, engine.options...)
+			assert.EqualError(t, interpreter.Execute(statements), "exit outside of a loop")
+		})
+	}
+}
+
+// failingWriter fails every write, like a closed pipe.
+type failingWriter struct{}
+
+var errClosed = errors.New("closed")
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errClosed
+}
+
+func TestInterpreterOutputError(t *testing.T) {
+	statements, err := lao.NewParser(
+		lao.NewTokenizer(strings.NewReader("a = 1\nprint a\nprint \"never\"")),
+	).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, engine := range engines {
+		t.Run(engine.name, func(t *testing.T) {
+			interpreter := lao.NewInterpreter(failingWriter{}, engine.options...)
+			err := interpreter.Execute(statements)
+			assert.True(t, errors.Is(err, errClosed))
+			assert.EqualError(t, err, "closed at line 2 column 1")
+		})
+	}
+}
+
+func BenchmarkInterpreter(b *testing.B) {
+	program := "d = 0\nfor c = 1 to 1000\nd = d .add. fib(5) .mul. c\nnext c\n" +
+		"function fib(e)\nif e .lt. 2 then return e\nreturn fib(e .sub. 1) .add. fib(e .sub. 2)\nend function"
+	statements, err := lao.NewParser(lao.NewTokenizer(strings.NewReader(program))).Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, engine := range engines {
+		b.Run(engine.name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				interpreter := lao.NewInterpreter(ioutil.Discard, engine.options...)
+				if err := interpreter.Execute(statements); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package lao
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// limits bound what a program may do. Zero is no bound.
+type limits struct {
+	steps         int
+	output        int
+	stringLength  int
+	variables     int
+	arrayElements int
+}
+
+// budget counts what a program uses against its limits. Steps and output
+// are counted again on every run, while variables and array elements are
+// counted for as long as they are defined.
+type budget struct {
+	max      limits
+	ctx      context.Context
+	done     <-chan struct{}
+	steps    int
+	output   int
+	defined  int // global variables
+	elements int // elements of the arrays defined
+}
+
+// start begins counting the steps and output of a run in ctx.
+func (b *budget) start(ctx context.Context) {
+	b.ctx, b.done = ctx, ctx.Done()
+	b.steps, b.output = 0, 0
+}
+
+// step counts a step taken at the instruction pointer ip.
+func (b *budget) step(ip int) error {
+	b.steps++
+	if b.max.steps > 0 && b.steps > b.max.steps {
+		return limitError(LimitSteps, ip, fmt.Errorf("exceeded the limit of %d steps", b.max.steps))
+	}
+
+	if b.done != nil {
+		select {
+		case <-b.done:
+			return limitError(LimitContext, ip, fmt.Errorf("stopped: %w", b.ctx.Err()))
+		default:
+		}
+	}
+
+	return nil
+}
+
+// write writes a line of output, unless it would go over the maximum
+// output, and returns the error of the writer.
+func (b *budget) write(w io.Writer, ip int, line string) error {
+	b.output += len(line) + 1
+	if b.max.output > 0 && b.output > b.max.output {
+		return limitError(LimitOutput, ip, fmt.Errorf("exceeded the limit of %d bytes of output", b.max.output))
+	}
+
+	_, err := io.WriteString(w, line+"\n")
+	return err
+}
+
+// checkString checks a value made by the program is not a string longer
+// than the maximum length.
+func (b *budget) checkString(ip int, value Value) error {
+	if b.max.stringLength <= 0 || value.Type() != ValueString {
+		return nil
+	}
+
+	if length := len(value.String()); length > b.max.stringLength {
+		return limitError(
+			LimitStringLength,
+			ip,
+			fmt.Errorf("string of %d bytes exceeds the limit of %d bytes", length, b.max.stringLength),
+		)
+	}
+	return nil
+}
+
+// define counts a new global variable.
+func (b *budget) define(ip int, name string) error {
+	if b.max.variables > 0 && b.defined >= b.max.variables {
+		return limitError(LimitVariables, ip, fmt.Errorf("variable %s exceeds the limit of %d variables", name, b.max.variables))
+	}
+
+	b.defined++
+	return nil
+}
+
+// allocate counts the elements of a new array with the bounds. They are
+// only counted when there is a limit; newArray checks the size of the
+// others.
+func (b *budget) allocate(ip int, name string, bounds []int) error {
+	if b.max.arrayElements == 0 {
+		return nil
+	}
+
+	size, ok := arraySize(bounds, b.max.arrayElements-b.elements)
+	if !ok {
+		return limitError(
+			LimitArrayElements,
+			ip,
+			fmt.Errorf("array %s exceeds the limit of %d array elements", name, b.max.arrayElements),
+		)
+	}
+
+	b.elements += size
+	return nil
+}
+
+// release stops counting the elements of an array that is no longer
+// defined.
+func (b *budget) release(value Value) {
+	if b.max.arrayElements > 0 && value.Type() == ValueArray {
+		b.elements -= len(value.Array().Elements)
+	}
+}
@@ -0,0 +1,509 @@
+package lao
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// MarshalProgram encodes a parsed program as JSON. Every node is an object
+// whose type field names its Go type, with its fields under their names in
+// lower camel case and the tokens it was parsed from under tokens. Token
+// kinds are written by name. Lists that are nil are left out, so they stay
+// nil when the program is read back with UnmarshalProgram.
+func MarshalProgram(program []Node) ([]byte, error) {
+	nodes, err := marshalNodes(program)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(nodes)
+}
+
+// UnmarshalProgram decodes a program encoded by MarshalProgram.
+func UnmarshalProgram(data []byte) ([]Node, error) {
+	var nodes []json.RawMessage
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		return nil, err
+	}
+
+	program := make([]Node, len(nodes))
+	for index, node := range nodes {
+		var err error
+		if program[index], err = unmarshalNode(node); err != nil {
+			return nil, err
+		}
+	}
+	return program, nil
+}
+
+type jsonToken struct {
+	Kind     string `json:"kind"`
+	Value    string `json:"value"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Offset   int    `json:"offset,omitempty"`
+	Length   int    `json:"length,omitempty"`
+	Leading  string `json:"leading,omitempty"`
+	Trailing string `json:"trailing,omitempty"`
+}
+
+// jsonObject holds the fields of a node while it is encoded.
+type jsonObject map[string]interface{}
+
+// set adds the field unless its value is nil.
+func (o jsonObject) set(name string, value interface{}) {
+	if value != nil {
+		o[name] = value
+	}
+}
+
+func marshalNodes(nodes []Node) ([]jsonObject, error) {
+	objects := make([]jsonObject, len(nodes))
+	for index, node := range nodes {
+		var err error
+		if objects[index], err = marshalNode(node); err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+func marshalNode(node Node) (jsonObject, error) {
+	o := jsonObject{"type": nodeTypeName(node)}
+	if tokens := node.Tokens(); tokens != nil {
+		list := make([]jsonToken, len(tokens))
+		for index, token := range tokens {
+			list[index] = jsonToken{
+				Kind:     token.Kind.String(),
+				Value:    token.Value,
+				Line:     token.Line,
+				Column:   token.Column,
+				Offset:   token.Offset,
+				Length:   token.Length,
+				Leading:  token.Leading,
+				Trailing: token.Trailing,
+			}
+		}
+		o["tokens"] = list
+	}
+
+	// children are the fields holding nodes, encoded after the switch
+	children := map[string]interface{}{}
+
+	switch n := node.(type) {
+	case AssignmentStatement:
+		children["variable"] = n.Variable
+		children["arithmeticExpression"] = n.ArithmeticExpression
+	case ArithmeticExpression:
+		children["left"] = n.Left
+		children["right"] = n.Right
+		o.set("operator", n.Operator.String())
+	case ConditionalExpression:
+		children["left"] = n.Left
+		children["right"] = n.Right
+		o.set("operator", n.Operator.String())
+	case PrintStatement:
+		children["argument"] = n.Argumenent
+	case Variable:
+		o.set("name", n.Name)
+		o.set("variableType", n.Type.String())
+		children["indexes"] = n.Indexes
+	case ReadStatement:
+		children["variable"] = n.Variable
+	case IfStatement:
+		children["condition"] = n.Condition
+		children["then"] = n.Then
+		children["elseIfs"] = n.ElseIfs
+		children["else"] = n.Else
+	case ElseIfClause:
+		children["condition"] = n.Condition
+		children["body"] = n.Body
+	case ParenthesizedExpression:
+		children["expression"] = n.Expression
+	case RealNumber:
+		o.set("value", n.Value)
+	case IntegerNumber:
+		o.set("value", n.Value)
+	case String:
+		o.set("value", n.Value)
+	case RemStatement, EndStatement, ExitStatement:
+	case LabelStatement:
+		o.set("name", n.Name)
+	case GotoStatement:
+		o.set("label", n.Label)
+	case GosubStatement:
+		o.set("label", n.Label)
+	case ReturnStatement:
+		children["value"] = n.Value
+	case ForStatement:
+		children["variable"] = n.Variable
+		children["from"] = n.From
+		children["to"] = n.To
+		children["step"] = n.Step
+		children["body"] = n.Body
+	case WhileStatement:
+		children["condition"] = n.Condition
+		children["body"] = n.Body
+	case DoStatement:
+		children["body"] = n.Body
+		children["condition"] = n.Condition
+	case SubStatement:
+		o.set("name", n.Name)
+		children["parameters"] = n.Parameters
+		children["body"] = n.Body
+	case FunctionStatement:
+		o.set("name", n.Name)
+		o.set("variableType", n.Type.String())
+		children["parameters"] = n.Parameters
+		children["body"] = n.Body
+	case CallStatement:
+		o.set("name", n.Name)
+		children["arguments"] = n.Arguments
+	case CallExpression:
+		o.set("name", n.Name)
+		children["arguments"] = n.Arguments
+	case DimStatement:
+		children["variable"] = n.Variable
+		children["bounds"] = n.Bounds
+	default:
+		return nil, fmt.Errorf("cannot marshal %T", node)
+	}
+
+	for name, child := range children {
+		var value interface{}
+		var err error
+		switch c := child.(type) {
+		case Node:
+			value, err = marshalNode(c)
+		case []Node:
+			if c != nil {
+				value, err = marshalNodes(c)
+			}
+		case []Variable:
+			if c != nil {
+				value, err = marshalNodes(variableNodes(c))
+			}
+		case []ElseIfClause:
+			if c != nil {
+				nodes := make([]Node, len(c))
+				for index, clause := range c {
+					nodes[index] = clause
+				}
+				value, err = marshalNodes(nodes)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		o.set(name, value)
+	}
+
+	return o, nil
+}
+
+func nodeTypeName(node Node) string {
+	return fmt.Sprintf("%T", node)[len("lao."):]
+}
+
+func variableNodes(variables []Variable) []Node {
+	nodes := make([]Node, len(variables))
+	for index, variable := range variables {
+		nodes[index] = variable
+	}
+	return nodes
+}
+
+// nodeDecoder reads the fields of a node. After the first error every
+// method returns a zero value, and err holds the error.
+type nodeDecoder struct {
+	fields map[string]json.RawMessage
+	err    error
+}
+
+func unmarshalNode(data json.RawMessage) (Node, error) {
+	d := &nodeDecoder{}
+	if err := json.Unmarshal(data, &d.fields); err != nil {
+		return nil, err
+	}
+	if d.fields == nil {
+		// Fields without a node are left out, never null
+		return nil, errors.New("a node cannot be null")
+	}
+
+	tokens := d.tokens()
+
+	var node Node
+	switch t := d.string("type"); t {
+	case "AssignmentStatement":
+		node = AssignmentStatement{
+			Variable:             d.variable("variable"),
+			ArithmeticExpression: d.node("arithmeticExpression"),
+			tokens:               tokens,
+		}
+	case "ArithmeticExpression":
+		node = ArithmeticExpression{
+			Left:     d.node("left"),
+			Right:    d.node("right"),
+			Operator: d.arithmeticOperator("operator"),
+			tokens:   tokens,
+		}
+	case "ConditionalExpression":
+		node = ConditionalExpression{
+			Left:     d.node("left"),
+			Right:    d.node("right"),
+			Operator: d.binaryOperator("operator"),
+			tokens:   tokens,
+		}
+	case "PrintStatement":
+		node = PrintStatement{Argumenent: d.node("argument"), tokens: tokens}
+	case "Variable":
+		node = Variable{
+			Name:    d.string("name"),
+			Type:    d.variableType("variableType"),
+			Indexes: d.nodes("indexes"),
+			tokens:  tokens,
+		}
+	case "ReadStatement":
+		node = ReadStatement{Variable: d.variable("variable"), tokens: tokens}
+	case "IfStatement":
+		node = IfStatement{
+			Condition: d.node("condition"),
+			Then:      d.nodes("then"),
+			ElseIfs:   d.elseIfs("elseIfs"),
+			Else:      d.nodes("else"),
+			tokens:    tokens,
+		}
+	case "ElseIfClause":
+		node = ElseIfClause{Condition: d.node("condition"), Body: d.nodes("body"), tokens: tokens}
+	case "ParenthesizedExpression":
+		node = ParenthesizedExpression{Expression: d.node("expression"), tokens: tokens}
+	case "RealNumber":
+		node = RealNumber{Value: d.string("value"), tokens: tokens}
+	case "IntegerNumber":
+		node = IntegerNumber{Value: d.string("value"), tokens: tokens}
+	case "String":
+		node = String{Value: d.string("value"), tokens: tokens}
+	case "RemStatement":
+		node = RemStatement{tokens: tokens}
+	case "EndStatement":
+		node = EndStatement{tokens: tokens}
+	case "ExitStatement":
+		node = ExitStatement{tokens: tokens}
+	case "LabelStatement":
+		node = LabelStatement{Name: d.string("name"), tokens: tokens}
+	case "GotoStatement":
+		node = GotoStatement{Label: d.string("label"), tokens: tokens}
+	case "GosubStatement":
+		node = GosubStatement{Label: d.string("label"), tokens: tokens}
+	case "ReturnStatement":
+		node = ReturnStatement{Value: d.node("value"), tokens: tokens}
+	case "ForStatement":
+		node = ForStatement{
+			Variable: d.variable("variable"),
+			From:     d.node("from"),
+			To:       d.node("to"),
+			Step:     d.node("step"),
+			Body:     d.nodes("body"),
+			tokens:   tokens,
+		}
+	case "WhileStatement":
+		node = WhileStatement{Condition: d.node("condition"), Body: d.nodes("body"), tokens: tokens}
+	case "DoStatement":
+		node = DoStatement{Body: d.nodes("body"), Condition: d.node("condition"), tokens: tokens}
+	case "SubStatement":
+		node = SubStatement{
+			Name:       d.string("name"),
+			Parameters: d.variables("parameters"),
+			Body:       d.nodes("body"),
+			tokens:     tokens,
+		}
+	case "FunctionStatement":
+		node = FunctionStatement{
+			Name:       d.string("name"),
+			Parameters: d.variables("parameters"),
+			Type:       d.variableType("variableType"),
+			Body:       d.nodes("body"),
+			tokens:     tokens,
+		}
+	case "CallStatement":
+		node = CallStatement{Name: d.string("name"), Arguments: d.nodes("arguments"), tokens: tokens}
+	case "CallExpression":
+		node = CallExpression{Name: d.string("name"), Arguments: d.nodes("arguments"), tokens: tokens}
+	case "DimStatement":
+		node = DimStatement{Variable: d.variable("variable"), Bounds: d.nodes("bounds"), tokens: tokens}
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown node type %q", t)
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return node, nil
+}
+
+// decode unmarshals the field into value and reports whether it was there.
+func (d *nodeDecoder) decode(name string, value interface{}) bool {
+	data, ok := d.fields[name]
+	if !ok || d.err != nil {
+		return false
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		d.err = fmt.Errorf("field %s: %w", name, err)
+		return false
+	}
+	return true
+}
+
+func (d *nodeDecoder) string(name string) string {
+	var s string
+	d.decode(name, &s)
+	return s
+}
+
+func (d *nodeDecoder) tokens() []Token {
+	var list []jsonToken
+	if !d.decode("tokens", &list) {
+		return nil
+	}
+
+	tokens := make([]Token, len(list))
+	for index, token := range list {
+		tokens[index] = Token{
+			Kind:     d.kind(token.Kind),
+			Value:    token.Value,
+			Line:     token.Line,
+			Column:   token.Column,
+			Offset:   token.Offset,
+			Length:   token.Length,
+			Leading:  token.Leading,
+			Trailing: token.Trailing,
+		}
+	}
+	return tokens
+}
+
+func (d *nodeDecoder) kind(name string) Kind {
+	for kind, kindName := range kindNames {
+		if kindName == name {
+			return kind
+		}
+	}
+	if d.err == nil {
+		d.err = fmt.Errorf("unknown token kind %q", name)
+	}
+	return 0
+}
+
+func (d *nodeDecoder) node(name string) Node {
+	var data json.RawMessage
+	if !d.decode(name, &data) {
+		return nil
+	}
+
+	node, err := unmarshalNode(data)
+	if err != nil {
+		d.err = err
+	}
+	return node
+}
+
+func (d *nodeDecoder) nodes(name string) []Node {
+	var list []json.RawMessage
+	if !d.decode(name, &list) {
+		return nil
+	}
+
+	nodes := make([]Node, len(list))
+	for index, data := range list {
+		node, err := unmarshalNode(data)
+		if err != nil {
+			d.err = err
+			return nil
+		}
+		nodes[index] = node
+	}
+	return nodes
+}
+
+func (d *nodeDecoder) variable(name string) Variable {
+	variable, ok := d.node(name).(Variable)
+	if !ok && d.err == nil {
+		d.err = fmt.Errorf("field %s must be a Variable", name)
+	}
+	return variable
+}
+
+func (d *nodeDecoder) variables(name string) []Variable {
+	nodes := d.nodes(name)
+	if nodes == nil {
+		return nil
+	}
+
+	variables := make([]Variable, len(nodes))
+	for index, node := range nodes {
+		variable, ok := node.(Variable)
+		if !ok && d.err == nil {
+			d.err = fmt.Errorf("field %s must hold Variables", name)
+		}
+		variables[index] = variable
+	}
+	return variables
+}
+
+func (d *nodeDecoder) elseIfs(name string) []ElseIfClause {
+	nodes := d.nodes(name)
+	if nodes == nil {
+		return nil
+	}
+
+	clauses := make([]ElseIfClause, len(nodes))
+	for index, node := range nodes {
+		clause, ok := node.(ElseIfClause)
+		if !ok && d.err == nil {
+			d.err = fmt.Errorf("field %s must hold ElseIfClauses", name)
+		}
+		clauses[index] = clause
+	}
+	return clauses
+}
+
+func (d *nodeDecoder) variableType(name string) VariableType {
+	s := d.string(name)
+	for _, t := range []VariableType{VariableReal, VariableInteger, VariableString} {
+		if t.String() == s {
+			return t
+		}
+	}
+	if d.err == nil {
+		d.err = fmt.Errorf("unknown variable type %q", s)
+	}
+	return 0
+}
+
+func (d *nodeDecoder) arithmeticOperator(name string) ArithmeticOperator {
+	s := d.string(name)
+	for operator := ArithmeticAdd; operator <= ArithmeticMultiplication; operator++ {
+		if operator.String() == s {
+			return operator
+		}
+	}
+	if d.err == nil {
+		d.err = fmt.Errorf("unknown arithmetic operator %q", s)
+	}
+	return 0
+}
+
+func (d *nodeDecoder) binaryOperator(name string) BinaryOperator {
+	s := d.string(name)
+	for operator := LessThan; operator <= Or; operator++ {
+		if operator.String() == s {
+			return operator
+		}
+	}
+	if d.err == nil {
+		d.err = fmt.Errorf("unknown operator %q", s)
+	}
+	return 0
+}
@@ -0,0 +1,116 @@
+package lao_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+func TestMarshalProgram(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		program string
+	}{
+		{
+			desc:    "simple statements",
+			program: "rem start\nread w\na = -(1 .add. b) .mul. 2\nprint \"a\"\nprint\nif .not. a .gt. 1 .and. gx .le. 2.5 then goto done\ndone:\nend.",
+		},
+		{
+			desc:    "blocks",
+			program: "for c = 1 to 3 step 1\nwhile a .lt. c\nexit\nwend\nnext c\ndo\na = a .add. 1\nloop until a .eq. 5\nif a .eq. 1 then\nprint 1\nelseif a .eq. 2 then\nelse\nendif",
+		},
+		{
+			desc:    "procedures and arrays",
+			program: "dim gm(2, 2)\ngm(1, 1) = abs(-1.5)\nsub show(w, gx as integer)\nprint w\nend sub\nfunction twice(a) as real\nreturn a .mul. 2\nend function\nshow(\"x\", 1)\ngosub sub1\nend.\nsub1:\nreturn",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			program, err := lao.NewParser(
+				lao.NewTokenizer(strings.NewReader(tC.program), lao.WithTrivia()),
+			).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := lao.MarshalProgram(program)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := lao.UnmarshalProgram(data)
+			assert.NoError(t, err)
+			assert.Equal(t, program, decoded)
+		})
+	}
+}
+
+func TestMarshalProgramFormat(t *testing.T) {
+	program, err := lao.NewParser(lao.NewTokenizer(strings.NewReader("print a"))).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := lao.MarshalProgram(program)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `[{
+		"type": "PrintStatement",
+		"tokens": [
+			{"kind": "keyword", "value": "print", "line": 1, "column": 1},
+			{"kind": "identifier", "value": "a", "line": 1, "column": 7}
+		],
+		"argument": {
+			"type": "Variable",
+			"tokens": [{"kind": "identifier", "value": "a", "line": 1, "column": 7}],
+			"name": "a",
+			"variableType": "integer"
+		}
+	}]`, string(data))
+}
+
+func TestUnmarshalProgramErrors(t *testing.T) {
+	testCases := []struct {
+		desc string
+		data string
+		err  string
+	}{
+		{
+			desc: "unknown node type",
+			data: `[{"type": "GotoStatement", "label": "a"}, {"type": "Jump"}]`,
+			err:  `unknown node type "Jump"`,
+		},
+		{
+			desc: "unknown token kind",
+			data: `[{"type": "EndStatement", "tokens": [{"kind": "dot"}]}]`,
+			err:  `unknown token kind "dot"`,
+		},
+		{
+			desc: "wrong field type",
+			data: `[{"type": "ReadStatement", "variable": {"type": "String", "value": "\"a\""}}]`,
+			err:  "field variable must be a Variable",
+		},
+		{
+			desc: "unknown variable type",
+			data: `[{"type": "ReadStatement", "variable": {"type": "Variable", "name": "a", "variableType": "bogus"}}]`,
+			err:  `unknown variable type "bogus"`,
+		},
+		{
+			desc: "null statement",
+			data: `[{"type": "EndStatement"}, null]`,
+			err:  "a node cannot be null",
+		},
+		{
+			desc: "null field",
+			data: `[{"type": "PrintStatement", "argument": null}]`,
+			err:  "a node cannot be null",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, err := lao.UnmarshalProgram([]byte(tC.data))
+			assert.EqualError(t, err, tC.err)
+		})
+	}
+}
@@ -41,7 +41,28 @@ const (
 	ArithmeticMultiplication
 )
 
-// ArithmeticExpression expression
+func (o ArithmeticOperator) String() string {
+	switch o {
+	case ArithmeticAdd:
+		return ".add."
+	case ArithmeticSubtract:
+		return ".sub."
+	case ArithmeticDivision:
+		return ".div."
+	case ArithmeticMultiplication:
+		return ".mul."
+	}
+	return "unknown"
+}
+
+var arithmeticPrecedence = map[ArithmeticOperator]int{
+	ArithmeticAdd:            6,
+	ArithmeticSubtract:       6,
+	ArithmeticDivision:       7,
+	ArithmeticMultiplication: 7,
+}
+
+// ArithmeticExpression expression. Left is nil for a negation.
 type ArithmeticExpression struct {
 	Left     Node
 	Right    Node
@@ -70,6 +91,30 @@ const (
 	Or
 )
 
+func (o BinaryOperator) String() string {
+	switch o {
+	case LessThan:
+		return ".lt."
+	case LessThanEqual:
+		return ".le."
+	case GreaterThan:
+		return ".gt."
+	case GreaterThanEqual:
+		return ".ge."
+	case Equal:
+		return ".eq."
+	case NotEqual:
+		return ".ne."
+	case And:
+		return ".and."
+	case Not:
+		return ".not."
+	case Or:
+		return ".or."
+	}
+	return "unknown"
+}
+
 var precedence = map[BinaryOperator]int{
 	Or:               1,
 	And:              2,
@@ -103,11 +148,25 @@ const (
 	VariableString
 )
 
-// Variable node
+func (t VariableType) String() string {
+	switch t {
+	case VariableReal:
+		return "real"
+	case VariableInteger:
+		return "integer"
+	case VariableString:
+		return "string"
+	}
+	return "unknown"
+}
+
+// Variable node. Indexes is set when the variable is an element of an
+// array, as in a(i, c).
 type Variable struct {
-	Name   string
-	Type   VariableType
-	tokens []Token
+	Name    string
+	Type    VariableType
+	Indexes []Node
+	tokens  []Token
 }
 
 func (a Variable) Tokens() []Token {
@@ -125,7 +184,7 @@ func (r ReadStatement) Tokens() []Token {
 	return r.tokens
 }
 
-// ConditionalExpression node
+// ConditionalExpression node. Left is nil for .not.
 type ConditionalExpression struct {
 	Left     Node
 	Right    Node
@@ -137,17 +196,42 @@ func (r ConditionalExpression) Tokens() []Token {
 	return r.tokens
 }
 
-// IfStatement node
+// IfStatement node. The one line form has a single statement in Then and
+// no other branches.
 type IfStatement struct {
-	Condition     ConditionalExpression
-	ThenStatement Node
-	tokens        []Token
+	Condition Node
+	Then      []Node
+	ElseIfs   []ElseIfClause
+	Else      []Node
+	tokens    []Token
 }
 
 func (r IfStatement) Tokens() []Token {
 	return r.tokens
 }
 
+// ElseIfClause node is an elseif branch of a block if.
+type ElseIfClause struct {
+	Condition Node
+	Body      []Node
+	tokens    []Token
+}
+
+func (r ElseIfClause) Tokens() []Token {
+	return r.tokens
+}
+
+// ParenthesizedExpression node keeps the parentheses written around an
+// expression.
+type ParenthesizedExpression struct {
+	Expression Node
+	tokens     []Token
+}
+
+func (r ParenthesizedExpression) Tokens() []Token {
+	return r.tokens
+}
+
 type RealNumber struct {
 	Value  string
 	tokens []Token
@@ -208,3 +292,131 @@ type GotoStatement struct {
 func (r GotoStatement) Tokens() []Token {
 	return r.tokens
 }
+
+// GosubStatement node jumps to a label and comes back when the subroutine
+// returns.
+type GosubStatement struct {
+	tokens []Token
+	Label  string
+}
+
+func (r GosubStatement) Tokens() []Token {
+	return r.tokens
+}
+
+// ReturnStatement node goes back to the statement after the last gosub, or
+// leaves the current sub or function. Value is the result of a function and
+// nil everywhere else.
+type ReturnStatement struct {
+	Value  Node
+	tokens []Token
+}
+
+func (r ReturnStatement) Tokens() []Token {
+	return r.tokens
+}
+
+// ForStatement node. Step is nil when the statement has no step clause.
+type ForStatement struct {
+	Variable Variable
+	From     Node
+	To       Node
+	Step     Node
+	Body     []Node
+	tokens   []Token
+}
+
+func (r ForStatement) Tokens() []Token {
+	return r.tokens
+}
+
+// WhileStatement node
+type WhileStatement struct {
+	Condition Node
+	Body      []Node
+	tokens    []Token
+}
+
+func (r WhileStatement) Tokens() []Token {
+	return r.tokens
+}
+
+// DoStatement node. The body always runs once before the condition is
+// checked.
+type DoStatement struct {
+	Body      []Node
+	Condition Node
+	tokens    []Token
+}
+
+func (r DoStatement) Tokens() []Token {
+	return r.tokens
+}
+
+// ExitStatement node leaves the innermost loop.
+type ExitStatement struct {
+	tokens []Token
+}
+
+func (r ExitStatement) Tokens() []Token {
+	return r.tokens
+}
+
+// SubStatement node declares a procedure. Parameters are local to the body.
+type SubStatement struct {
+	Name       string
+	Parameters []Variable
+	Body       []Node
+	tokens     []Token
+}
+
+func (r SubStatement) Tokens() []Token {
+	return r.tokens
+}
+
+// FunctionStatement node declares a procedure that returns a value of Type.
+type FunctionStatement struct {
+	Name       string
+	Parameters []Variable
+	Type       VariableType
+	Body       []Node
+	tokens     []Token
+}
+
+func (r FunctionStatement) Tokens() []Token {
+	return r.tokens
+}
+
+// CallStatement node calls a sub or function, discarding its result.
+type CallStatement struct {
+	Name      string
+	Arguments []Node
+	tokens    []Token
+}
+
+func (r CallStatement) Tokens() []Token {
+	return r.tokens
+}
+
+// CallExpression node calls a function and uses its result.
+type CallExpression struct {
+	Name      string
+	Arguments []Node
+	tokens    []Token
+}
+
+func (r CallExpression) Tokens() []Token {
+	return r.tokens
+}
+
+// DimStatement node declares an array. Each dimension goes from 0 to its
+// bound, and the elements take the type of the variable.
+type DimStatement struct {
+	Variable Variable
+	Bounds   []Node
+	tokens   []Token
+}
+
+func (r DimStatement) Tokens() []Token {
+	return r.tokens
+}
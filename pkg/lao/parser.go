@@ -1,59 +1,172 @@
 package lao
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
 
+// ErrUnexpectedEnd is wrapped by parse errors caused by input that stops
+// in the middle of a statement, such as a for without its next. Callers
+// reading input interactively can use it to ask for more lines.
+var ErrUnexpectedEnd = errors.New("unexpected end of input")
+
 // Parser parses tokens into an AST
 type Parser interface {
 	Parse() ([]Node, error)
 }
 
 type parser struct {
+	tokenizer *lookahead
+	loops     int                     // depth of the loops around the current statement
+	blocks    int                     // depth of the blocks around the current statement
+	procedure string                  // sub or function whose body is being parsed
+	declared  map[string]VariableType // parameters of that sub or function
+	arrays    map[string]bool         // arrays declared with dim so far
+	recovery  bool
+	errors    ParseErrors
+}
+
+// ParserOption configures a parser
+type ParserOption func(*parser)
+
+// WithRecovery makes the parser skip to the next line after a syntax error
+// and keep going. Parse then returns every error found as ParseErrors.
+func WithRecovery() ParserOption {
+	return func(p *parser) {
+		p.recovery = true
+	}
+}
+
+func NewParser(tokenizer Tokenizer, options ...ParserOption) Parser {
+	p := &parser{
+		tokenizer: &lookahead{tokenizer: tokenizer},
+		arrays:    map[string]bool{},
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	return p
+}
+
+// lookahead wraps a tokenizer so the parser can look at the token after the
+// current one.
+type lookahead struct {
 	tokenizer Tokenizer
+	current   Token
+	peeked    bool
+	more      bool
 }
 
-func NewParser(tokenizer Tokenizer) Parser {
-	return parser{tokenizer: tokenizer}
+func (l *lookahead) Current() Token {
+	if l.peeked {
+		return l.current
+	}
+	return l.tokenizer.Current()
 }
 
-func (p parser) Parse() ([]Node, error) {
+func (l *lookahead) Next() bool {
+	if l.peeked {
+		l.peeked = false
+		return l.more
+	}
+	return l.tokenizer.Next()
+}
+
+func (l *lookahead) Err() error {
+	return l.tokenizer.Err()
+}
+
+// Peek returns the token after the current one without moving past it.
+func (l *lookahead) Peek() Token {
+	if !l.peeked {
+		l.current = l.tokenizer.Current()
+		l.more = l.tokenizer.Next()
+		l.peeked = true
+	}
+	return l.tokenizer.Current()
+}
+
+func (p *parser) Parse() ([]Node, error) {
 
 	nodes := []Node{}
 
 	for {
 		switch p.tokenizer.Current().Kind {
-		case KindIdentifier, KindKeyword, KindLabel:
+		case KindIdentifier, KindKeyword, KindLabel, KindIllegal:
+			start := p.tokenizer.Current()
 			node, err := p.parseStatement()
 			if err != nil {
+				if p.recover(start, err) {
+					continue
+				}
 				return nodes, err
 			}
 
 			nodes = append(nodes, node)
 			continue
 		case KindEnd:
+			// Illegal tokens were reported where they were found
+			var parseError *ParseError
+			if err := p.tokenizer.Err(); err != nil && !errors.As(err, &parseError) {
+				return nodes, err
+			}
+			if len(p.errors) > 0 {
+				return nodes, p.errors
+			}
 			return nodes, nil
 		}
 		p.tokenizer.Next()
 	}
 }
 
-func (p parser) parseStatement() (Node, error) {
+// recover records a syntax error in recovery mode and skips the rest of the
+// line the failed statement started on. It reports whether parsing can go
+// on.
+func (p *parser) recover(start Token, err error) bool {
+	var parseError *ParseError
+	if !p.recovery || !errors.As(err, &parseError) {
+		return false
+	}
+	p.errors = append(p.errors, parseError)
+
+	for current := p.tokenizer.Current(); current.Kind != KindEnd && current.Line == start.Line; current = p.tokenizer.Current() {
+		p.tokenizer.Next()
+	}
+
+	return true
+}
+
+func (p *parser) parseStatement() (Node, error) {
 	switch p.tokenizer.Current().Kind {
 	case KindIdentifier:
+		if p.tokenizer.Peek().Kind == KindLeftParenthesis && !p.arrays[strings.ToLower(p.tokenizer.Current().Value)] {
+			return p.parseCallStatement()
+		}
 		return p.parseAssignmentStatement()
 	case KindKeyword:
 		return p.parseKeywordStatement()
 	case KindLabel:
 		return p.parseLabelStatement()
+	case KindIllegal:
+		return nil, illegalTokenError(p.tokenizer.Current())
 	}
 
-	return nil, fmt.Errorf("unable to parse statement")
+	return nil, errorAt(p.tokenizer.Current(), nil, "unable to parse statement")
 }
 
-func (p parser) parseLabelStatement() (Node, error) {
+func (p *parser) parseLabelStatement() (Node, error) {
 	current := p.tokenizer.Current()
+	if p.procedure != "" {
+		return nil, errorAt(current, nil, "labels are not allowed inside a %s", p.procedure)
+	}
+
+	// Jumps only find the labels of the top level
+	if p.blocks > 0 {
+		return nil, errorAt(current, nil, "labels must be at the top level, not inside a block")
+	}
 
 	p.tokenizer.Next()
 
@@ -63,53 +176,36 @@ func (p parser) parseLabelStatement() (Node, error) {
 	}, nil
 }
 
-func (p parser) parseAssignmentStatement() (Node, error) {
+func (p *parser) parseAssignmentStatement() (Node, error) {
 	variable, err := p.parseVariable()
 	if err != nil {
 		return nil, err
 	}
 
-	tokens := append(variable.Tokens(), p.tokenizer.Current())
+	return p.parseAssignment(variable.(Variable))
+}
+
+// parseAssignment parses the = and the value assigned to the variable.
+func (p *parser) parseAssignment(variable Variable) (Node, error) {
+	tokens := append(append([]Token{}, variable.Tokens()...), p.tokenizer.Current())
 	if p.tokenizer.Current().Kind != KindAssignment {
-		return nil, fmt.Errorf(
-			"Not proper variable statment line %d column %d",
-			p.tokenizer.Current().Line,
-			p.tokenizer.Current().Column,
-		)
+		return nil, errorAt(p.tokenizer.Current(), []Kind{KindAssignment}, "Not proper variable statment")
 	}
 	p.tokenizer.Next() // eat assignemt token
 
-	var (
-		left,
-		exp Node
-	)
-	run(func() error {
-		left, err = p.parseAtom(p.tokenizer.Current().Line)
-		return err
-	}, func() error {
-
-		exp, err = p.parseArithmeticExpression(left, 0)
-		return err
-	})
+	exp, err := p.parseExpression(0)
 	if err != nil {
 		return nil, err
 	}
 
 	return AssignmentStatement{
-		Variable:             variable.(Variable),
+		Variable:             variable,
 		ArithmeticExpression: exp,
 		tokens:               append(tokens, exp.Tokens()...),
 	}, nil
 }
 
-var arithmeticPrecedence = map[ArithmeticOperator]int{
-	ArithmeticAdd:            6,
-	ArithmeticSubtract:       6,
-	ArithmeticDivision:       7,
-	ArithmeticMultiplication: 7,
-}
-
-func (p parser) getArithmeticOperator() ArithmeticOperator {
+func (p *parser) getArithmeticOperator() ArithmeticOperator {
 	switch strings.ToLower(p.tokenizer.Current().Value) {
 	case ".add.":
 		return ArithmeticAdd
@@ -123,49 +219,7 @@ func (p parser) getArithmeticOperator() ArithmeticOperator {
 	return 0
 }
 
-func (p parser) parseArithmeticExpression(
-	left Node,
-	prec int,
-) (Node, error) {
-
-	current := p.tokenizer.Current()
-	if p.tokenizer.Current().Kind == KindArithmeticOperator {
-		op := p.getArithmeticOperator()
-		nextPrec := arithmeticPrecedence[op]
-		if nextPrec > prec {
-			p.tokenizer.Next()
-
-			var (
-				atom,
-				right Node
-			)
-			err := run(func() error {
-				var err error
-				atom, err = p.parseAtom(current.Line)
-				return err
-			}, func() error {
-				var err error
-				right, err = p.parseArithmeticExpression(atom, nextPrec)
-				return err
-			})
-
-			if err != nil {
-				return nil, err
-			}
-
-			return p.parseArithmeticExpression(ArithmeticExpression{
-				Left:     left,
-				Right:    right,
-				Operator: op,
-				tokens:   append(append(left.Tokens(), current), right.Tokens()...),
-			}, prec)
-		}
-	}
-
-	return left, nil
-}
-
-func (p parser) parseReadStatement() (Node, error) {
+func (p *parser) parseReadStatement() (Node, error) {
 	current := p.tokenizer.Current()
 	p.tokenizer.Next()
 	variable, err := p.parseVariable()
@@ -179,7 +233,7 @@ func (p parser) parseReadStatement() (Node, error) {
 	}, nil
 }
 
-func (p parser) parseKeywordStatement() (Node, error) {
+func (p *parser) parseKeywordStatement() (Node, error) {
 	switch strings.ToLower(p.tokenizer.Current().Value) {
 	case "if":
 		return p.parseIfStatement()
@@ -193,27 +247,608 @@ func (p parser) parseKeywordStatement() (Node, error) {
 		return p.parseEndStatement()
 	case "goto":
 		return p.parseGotoStatement()
+	case "gosub":
+		return p.parseGosubStatement()
+	case "return":
+		return p.parseReturnStatement()
+	case "sub", "function":
+		return p.parseProcedure()
+	case "for":
+		return p.parseForStatement()
+	case "while":
+		return p.parseWhileStatement()
+	case "do":
+		return p.parseDoStatement()
+	case "exit":
+		return p.parseExitStatement()
+	case "dim":
+		return p.parseDimStatement()
+	case "next", "wend", "loop", "elseif", "else", "endif":
+		current := p.tokenizer.Current()
+		return nil, errorAt(
+			current,
+			nil,
+			"%s without %s",
+			strings.ToLower(current.Value),
+			blockOpeners[strings.ToLower(current.Value)],
+		)
 	}
-	return nil, nil
+
+	current := p.tokenizer.Current()
+	return nil, errorAt(current, nil, "unexpected keyword %s", current.Value)
+}
+
+// blockOpeners maps the keyword closing a block to the one opening it.
+var blockOpeners = map[string]string{
+	"next":   "for",
+	"wend":   "while",
+	"loop":   "do",
+	"elseif": "if",
+	"else":   "if",
+	"endif":  "if",
 }
 
-func (p parser) parseGotoStatement() (Node, error) {
+// parseBlock parses statements until it finds one of the terminator
+// keywords, which is left as the current token. A terminator can be two
+// keywords such as "end sub".
+func (p *parser) parseBlock(terminators ...string) ([]Node, error) {
+	nodes := []Node{}
+
+	p.blocks++
+	defer func() { p.blocks-- }()
+
+	for {
+		current := p.tokenizer.Current()
+		switch current.Kind {
+		case KindKeyword:
+			for _, terminator := range terminators {
+				if p.atKeywords(strings.Fields(terminator)...) {
+					return nodes, nil
+				}
+			}
+			fallthrough
+		case KindIdentifier, KindLabel, KindIllegal:
+			node, err := p.parseStatement()
+			if err != nil {
+				if p.recover(current, err) {
+					continue
+				}
+				return nodes, err
+			}
+
+			nodes = append(nodes, node)
+			continue
+		case KindEnd:
+			return nodes, ErrUnexpectedEnd
+		}
+		p.tokenizer.Next()
+	}
+}
+
+// atKeywords reports whether the current token, and the next one on the same
+// line when given, are the keywords.
+func (p *parser) atKeywords(keywords ...string) bool {
 	current := p.tokenizer.Current()
-	p.tokenizer.Next() // Eat goto
-	next := p.tokenizer.Current()
+	if current.Kind != KindKeyword || strings.ToLower(current.Value) != keywords[0] {
+		return false
+	}
+	if len(keywords) == 1 {
+		return true
+	}
+
+	next := p.tokenizer.Peek()
+	return next.Kind == KindKeyword &&
+		next.Line == current.Line &&
+		strings.ToLower(next.Value) == keywords[1]
+}
+
+func (p *parser) expectKeyword(keyword string) (Token, error) {
+	current := p.tokenizer.Current()
+	if current.Kind != KindKeyword || strings.ToLower(current.Value) != keyword {
+		return current, errorAt(current, []Kind{KindKeyword}, "expected %s but found %q", keyword, current.Value)
+	}
+	p.tokenizer.Next()
+
+	return current, nil
+}
+
+// parseLoopBody parses the body of a loop so that exit statements inside it
+// are accepted.
+func (p *parser) parseLoopBody(terminator string) ([]Node, error) {
+	p.loops++
+	defer func() { p.loops-- }()
+
+	return p.parseBlock(terminator)
+}
+
+// missingTerminator reports a block that reached the end of the input
+// before its closing keyword.
+func missingTerminator(err error, terminator string, opener Token) error {
+	if err != ErrUnexpectedEnd {
+		return err
+	}
+
+	return &ParseError{
+		Line:     opener.Line,
+		Column:   opener.Column,
+		Expected: []Kind{KindKeyword},
+		Found:    KindEnd,
+		Err:      fmt.Errorf("%w: missing %s for the %s statement", err, terminator, strings.ToLower(opener.Value)),
+	}
+}
+
+// parseCondition parses an expression that must evaluate to a boolean, as
+// used by if, while and until.
+func (p *parser) parseCondition() (Node, error) {
+	current := p.tokenizer.Current()
+
+	condition, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isCondition(condition) {
+		return nil, errorAt(current, nil, "Invalid conditional expresion")
+	}
+
+	return condition, nil
+}
+
+func isCondition(node Node) bool {
+	switch n := node.(type) {
+	case ConditionalExpression:
+		return true
+	case ParenthesizedExpression:
+		return isCondition(n.Expression)
+	}
+	return false
+}
+
+func (p *parser) parseWhileStatement() (Node, error) {
+	current := p.tokenizer.Current()
+	p.tokenizer.Next() // Eat while
+
+	condition, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseLoopBody("wend")
+	if err != nil {
+		return nil, missingTerminator(err, "wend", current)
+	}
+
+	tokens := append([]Token{current}, condition.Tokens()...)
+	tokens = appendTokens(tokens, body)
+	tokens = append(tokens, p.tokenizer.Current())
+	p.tokenizer.Next() // Eat wend
+
+	return WhileStatement{
+		Condition: condition,
+		Body:      body,
+		tokens:    tokens,
+	}, nil
+}
+
+func (p *parser) parseDoStatement() (Node, error) {
+	current := p.tokenizer.Current()
+	p.tokenizer.Next() // Eat do
 
-	if next.Kind != KindIdentifier || current.Line != next.Line {
-		return nil, fmt.Errorf("Invalid goto statement at line %d column %d", next.Line, next.Column)
+	body, err := p.parseLoopBody("loop")
+	if err != nil {
+		return nil, missingTerminator(err, "loop", current)
+	}
+
+	tokens := []Token{current}
+	tokens = appendTokens(tokens, body)
+	tokens = append(tokens, p.tokenizer.Current())
+	p.tokenizer.Next() // Eat loop
+
+	until, err := p.expectKeyword("until")
+	if err != nil {
+		return nil, err
+	}
+
+	condition, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+
+	return DoStatement{
+		Body:      body,
+		Condition: condition,
+		tokens:    append(append(tokens, until), condition.Tokens()...),
+	}, nil
+}
+
+func (p *parser) parseExitStatement() (Node, error) {
+	current := p.tokenizer.Current()
+	if p.loops == 0 {
+		return nil, errorAt(current, nil, "exit outside of a loop")
 	}
 	p.tokenizer.Next()
 
+	return ExitStatement{tokens: []Token{current}}, nil
+}
+
+func (p *parser) parseForStatement() (Node, error) {
+	current := p.tokenizer.Current()
+	p.tokenizer.Next() // Eat for
+
+	tokens := []Token{current}
+
+	node, err := p.parseVariable()
+	if err != nil {
+		return nil, err
+	}
+	variable := node.(Variable)
+	if variable.Indexes != nil {
+		return nil, errorAt(variable.tokens[0], nil, "for counter %s cannot be an array element", variable.Name)
+	}
+	if variable.Type == VariableString {
+		return nil, errorAt(variable.tokens[0], nil, "for counter %s must be an integer or real variable", variable.Name)
+	}
+	tokens = append(tokens, variable.Tokens()...)
+
+	assignment := p.tokenizer.Current()
+	if assignment.Kind != KindAssignment {
+		return nil, errorAt(assignment, []Kind{KindAssignment}, "expected = after for counter")
+	}
+	tokens = append(tokens, assignment)
+	p.tokenizer.Next()
+
+	var from, to, step Node
+	err = run(func() error {
+		from, err = p.parseExpression(0)
+		return err
+	}, func() error {
+		var keyword Token
+		keyword, err = p.expectKeyword("to")
+		tokens = append(append(tokens, from.Tokens()...), keyword)
+		return err
+	}, func() error {
+		to, err = p.parseExpression(0)
+		return err
+	}, func() error {
+		tokens = append(tokens, to.Tokens()...)
+		next := p.tokenizer.Current()
+		if next.Kind != KindKeyword || strings.ToLower(next.Value) != "step" || next.Line != current.Line {
+			return nil
+		}
+		p.tokenizer.Next()
+
+		step, err = p.parseExpression(0)
+		if err == nil {
+			tokens = append(append(tokens, next), step.Tokens()...)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseLoopBody("next")
+	if err != nil {
+		return nil, missingTerminator(err, "next", current)
+	}
+	tokens = appendTokens(tokens, body)
+
+	next := p.tokenizer.Current()
+	tokens = append(tokens, next)
+	p.tokenizer.Next() // Eat next
+
+	if counter := p.tokenizer.Current(); counter.Kind == KindIdentifier && counter.Line == next.Line {
+		if strings.ToLower(counter.Value) != variable.Name {
+			return nil, errorAt(counter, nil, "next %s does not match for %s", counter.Value, variable.Name)
+		}
+		tokens = append(tokens, counter)
+		p.tokenizer.Next()
+	}
+
+	return ForStatement{
+		Variable: variable,
+		From:     from,
+		To:       to,
+		Step:     step,
+		Body:     body,
+		tokens:   tokens,
+	}, nil
+}
+
+func (p *parser) parseGotoStatement() (Node, error) {
+	tokens, err := p.parseJump()
+	if err != nil {
+		return nil, err
+	}
+
 	return GotoStatement{
-		Label:  next.Value,
-		tokens: []Token{current, next},
+		Label:  tokens[1].Value,
+		tokens: tokens,
 	}, nil
 }
 
-func (p parser) getBinaryOperator() BinaryOperator {
+func (p *parser) parseGosubStatement() (Node, error) {
+	tokens, err := p.parseJump()
+	if err != nil {
+		return nil, err
+	}
+
+	return GosubStatement{
+		Label:  tokens[1].Value,
+		tokens: tokens,
+	}, nil
+}
+
+// parseJump parses a goto or gosub keyword followed by the label it jumps
+// to.
+func (p *parser) parseJump() ([]Token, error) {
+	current := p.tokenizer.Current()
+	if p.procedure != "" {
+		return nil, errorAt(current, nil, "%s is not allowed inside a %s", strings.ToLower(current.Value), p.procedure)
+	}
+
+	p.tokenizer.Next() // Eat goto or gosub
+	next := p.tokenizer.Current()
+
+	// Labels such as loop: share their name with a keyword
+	if (next.Kind != KindIdentifier && next.Kind != KindKeyword) || current.Line != next.Line {
+		return nil, errorAt(next, []Kind{KindIdentifier}, "Invalid %s statement", strings.ToLower(current.Value))
+	}
+	p.tokenizer.Next()
+
+	return []Token{current, next}, nil
+}
+
+func (p *parser) parseReturnStatement() (Node, error) {
+	current := p.tokenizer.Current()
+	p.tokenizer.Next()
+
+	if p.procedure != "function" {
+		return ReturnStatement{tokens: []Token{current}}, nil
+	}
+
+	if p.tokenizer.Current().Line != current.Line {
+		return nil, errorAt(current, nil, "return inside a function needs a value")
+	}
+
+	value, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return ReturnStatement{
+		Value:  value,
+		tokens: append([]Token{current}, value.Tokens()...),
+	}, nil
+}
+
+// parseProcedure parses a sub or function declaration:
+//
+//	sub name(a, gx as integer)
+//	...
+//	end sub
+//
+//	function name(a) as real
+//	...
+//	return expression
+//	end function
+func (p *parser) parseProcedure() (Node, error) {
+	current := p.tokenizer.Current()
+	keyword := strings.ToLower(current.Value)
+	if p.blocks > 0 || p.procedure != "" {
+		return nil, errorAt(current, nil, "%s must be declared at the top level", keyword)
+	}
+	p.tokenizer.Next() // Eat sub or function
+
+	name := p.tokenizer.Current()
+	if name.Kind != KindIdentifier {
+		return nil, errorAt(name, []Kind{KindIdentifier}, "expected %s name", keyword)
+	}
+
+	if err := checkBuiltinName(name); err != nil {
+		return nil, err
+	}
+
+	returns, err := p.variableType(name)
+	if err != nil {
+		return nil, err
+	}
+	signature := Variable{Name: strings.ToLower(name.Value), Type: returns}
+	p.tokenizer.Next()
+
+	tokens := []Token{current, name}
+
+	open := p.tokenizer.Current()
+	if open.Kind != KindLeftParenthesis {
+		return nil, errorAt(open, []Kind{KindLeftParenthesis}, "expected ( after %s name", keyword)
+	}
+	tokens = append(tokens, open)
+	p.tokenizer.Next()
+
+	parameters := []Variable{}
+	for p.tokenizer.Current().Kind != KindRightParenthesis {
+		if len(parameters) > 0 {
+			comma := p.tokenizer.Current()
+			if comma.Kind != KindComma {
+				return nil, errorAt(comma, []Kind{KindComma, KindRightParenthesis}, "expected , or )")
+			}
+			tokens = append(tokens, comma)
+			p.tokenizer.Next()
+		}
+
+		parameter, err := p.parseTypedVariable()
+		if err != nil {
+			return nil, err
+		}
+		parameters = append(parameters, parameter)
+		tokens = append(tokens, parameter.Tokens()...)
+	}
+	tokens = append(tokens, p.tokenizer.Current())
+	p.tokenizer.Next() // Eat )
+
+	if keyword == "function" && p.atKeywords("as") {
+		signature.tokens = nil
+		if signature, err = p.parseType(signature); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, signature.Tokens()...)
+	}
+
+	p.procedure = keyword
+	p.declared = map[string]VariableType{}
+	for _, parameter := range parameters {
+		p.declared[parameter.Name] = parameter.Type
+	}
+
+	body, err := p.parseBlock("end " + keyword)
+	p.procedure = ""
+	p.declared = nil
+	if err != nil {
+		return nil, missingTerminator(err, "end "+keyword, current)
+	}
+
+	tokens = appendTokens(tokens, body)
+	tokens = append(tokens, p.tokenizer.Current())
+	p.tokenizer.Next() // Eat end
+	tokens = append(tokens, p.tokenizer.Current())
+	p.tokenizer.Next() // Eat sub or function
+
+	if keyword == "sub" {
+		return SubStatement{
+			Name:       signature.Name,
+			Parameters: parameters,
+			Body:       body,
+			tokens:     tokens,
+		}, nil
+	}
+
+	return FunctionStatement{
+		Name:       signature.Name,
+		Parameters: parameters,
+		Type:       signature.Type,
+		Body:       body,
+		tokens:     tokens,
+	}, nil
+}
+
+// parseTypedVariable parses a variable optionally followed by an explicit
+// type, as in gx as integer.
+func (p *parser) parseTypedVariable() (Variable, error) {
+	node, err := p.parseVariable()
+	if err != nil {
+		return Variable{}, err
+	}
+	variable := node.(Variable)
+	if variable.Indexes != nil {
+		return Variable{}, errorAt(variable.tokens[0], nil, "parameter %s cannot be an array element", variable.Name)
+	}
+
+	if !p.atKeywords("as") {
+		return variable, nil
+	}
+
+	return p.parseType(variable)
+}
+
+// parseType parses an as clause and gives its type to the variable.
+func (p *parser) parseType(variable Variable) (Variable, error) {
+	as := p.tokenizer.Current()
+	p.tokenizer.Next() // Eat as
+
+	name := p.tokenizer.Current()
+	switch strings.ToLower(name.Value) {
+	case "integer":
+		variable.Type = VariableInteger
+	case "real":
+		variable.Type = VariableReal
+	case "string":
+		variable.Type = VariableString
+	default:
+		return Variable{}, errorAt(name, []Kind{KindIdentifier}, "expected integer, real or string after as")
+	}
+	p.tokenizer.Next()
+
+	variable.tokens = append(append(variable.tokens, as), name)
+	return variable, nil
+}
+
+// parseCall parses a name followed by a list of arguments in parentheses.
+func (p *parser) parseCall() (string, []Node, []Token, error) {
+	name := p.tokenizer.Current()
+	p.tokenizer.Next() // Eat name
+
+	arguments, tokens, err := p.parseArguments()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	return strings.ToLower(name.Value), arguments, append([]Token{name}, tokens...), nil
+}
+
+// parseArguments parses a list of expressions in parentheses, as used by
+// calls and array indexes.
+func (p *parser) parseArguments() ([]Node, []Token, error) {
+	tokens := []Token{p.tokenizer.Current()}
+	p.tokenizer.Next() // Eat (
+
+	arguments := []Node{}
+	for p.tokenizer.Current().Kind != KindRightParenthesis {
+		if len(arguments) > 0 {
+			comma := p.tokenizer.Current()
+			if comma.Kind != KindComma {
+				return nil, nil, errorAt(comma, []Kind{KindComma, KindRightParenthesis}, "expected , or )")
+			}
+			tokens = append(tokens, comma)
+			p.tokenizer.Next()
+		}
+
+		argument, err := p.parseExpression(0)
+		if err != nil {
+			return nil, nil, err
+		}
+		arguments = append(arguments, argument)
+		tokens = append(tokens, argument.Tokens()...)
+	}
+	tokens = append(tokens, p.tokenizer.Current())
+	p.tokenizer.Next() // Eat )
+
+	return arguments, tokens, nil
+}
+
+func (p *parser) parseCallStatement() (Node, error) {
+	current := p.tokenizer.Current()
+	name, arguments, tokens, err := p.parseCall()
+	if err != nil {
+		return nil, err
+	}
+
+	// An array declared in an earlier input, a(i) = value
+	if p.tokenizer.Current().Kind == KindAssignment {
+		t, err := p.variableType(current)
+		if err != nil {
+			return nil, err
+		}
+
+		return p.parseAssignment(Variable{
+			Name:    name,
+			Type:    t,
+			Indexes: arguments,
+			tokens:  tokens,
+		})
+	}
+
+	if _, ok := builtins[name]; ok {
+		return nil, errorAt(current, nil, "built-in function %s must be used in an expression", name)
+	}
+
+	return CallStatement{
+		Name:      name,
+		Arguments: arguments,
+		tokens:    tokens,
+	}, nil
+}
+
+func (p *parser) getBinaryOperator() BinaryOperator {
 	switch strings.ToLower(p.tokenizer.Current().Value) {
 	case ".and.":
 		return And
@@ -238,12 +873,12 @@ func (p parser) getBinaryOperator() BinaryOperator {
 	return 0
 }
 
-func (p parser) parseString() (Node, error) {
+func (p *parser) parseString() (Node, error) {
 	current := p.tokenizer.Current()
 	return String{Value: current.Value, tokens: []Token{current}}, nil
 }
 
-func (p parser) parseNumber() (Node, error) {
+func (p *parser) parseNumber() (Node, error) {
 	current := p.tokenizer.Current()
 	switch current.Kind {
 	case KindInteger:
@@ -251,10 +886,13 @@ func (p parser) parseNumber() (Node, error) {
 	case KindReal:
 		return RealNumber{Value: current.Value, tokens: []Token{current}}, nil
 	}
-	return nil, fmt.Errorf("Not a number")
+	return nil, errorAt(current, []Kind{KindInteger, KindReal}, "Not a number")
 }
 
-func (p parser) parseAtom(line int) (Node, error) {
+// operandKinds are the kinds of token an operand can start with.
+var operandKinds = []Kind{KindInteger, KindReal, KindString, KindIdentifier}
+
+func (p *parser) parseAtom() (Node, error) {
 
 	current := p.tokenizer.Current()
 	switch p.tokenizer.Current().Kind {
@@ -265,64 +903,148 @@ func (p parser) parseAtom(line int) (Node, error) {
 		defer p.tokenizer.Next()
 		return p.parseString()
 	case KindIdentifier:
+		if p.tokenizer.Peek().Kind == KindLeftParenthesis && !p.arrays[strings.ToLower(current.Value)] {
+			name, arguments, tokens, err := p.parseCall()
+			if err != nil {
+				return nil, err
+			}
+
+			if b, ok := builtins[name]; ok && !b.accepts(len(arguments)) {
+				return nil, errorAt(current, nil, "%s takes %s but got %d", name, b.arity(), len(arguments))
+			}
+
+			return CallExpression{
+				Name:      name,
+				Arguments: arguments,
+				tokens:    tokens,
+			}, nil
+		}
 		return p.parseVariable()
+	case KindIllegal:
+		return nil, illegalTokenError(current)
 	}
-	return nil, fmt.Errorf("unxpected token %s at line: %d column: %d", current.Value, current.Line, current.Column)
+	return nil, errorAt(current, operandKinds, "unxpected token %s", current.Value)
 }
 
-func (p parser) parseExpresion(
-	left Node,
-	prec int,
-) (Node, error) {
-	current := p.tokenizer.Current()
-	if current.Kind == KindLogicalOperator ||
-		current.Kind == KindRelationalOperator {
+// parseExpression parses arithmetic, relational and logical expressions with
+// a single grammar. It climbs the precedence tables in nodes.go and stops at
+// the first operator that does not bind tighter than prec.
+func (p *parser) parseExpression(prec int) (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
 
-		operator := p.getBinaryOperator()
-		nextPrec := precedence[operator]
+	for {
+		current := p.tokenizer.Current()
+
+		var nextPrec int
+		switch current.Kind {
+		case KindArithmeticOperator:
+			nextPrec = arithmeticPrecedence[p.getArithmeticOperator()]
+		case KindRelationalOperator, KindLogicalOperator:
+			if operator := p.getBinaryOperator(); operator != Not {
+				nextPrec = precedence[operator]
+			}
+		}
+
+		if nextPrec <= prec {
+			return left, nil
+		}
 
+		arithmetic := p.getArithmeticOperator()
+		binary := p.getBinaryOperator()
 		p.tokenizer.Next()
-		if nextPrec > prec {
-			atom, err := p.parseAtom(current.Line)
-			if err != nil {
-				return nil, err
-			}
-			right, err := p.parseExpresion(atom, nextPrec)
-			if err != nil {
-				return nil, err
-			}
 
-			var leftTokens []Token
-			if left != nil {
-				leftTokens = left.Tokens()
-			}
+		// left to right logic
+		right, err := p.parseExpression(nextPrec)
+		if err != nil {
+			return nil, err
+		}
 
-			// left to right logic
-			return p.parseExpresion(ConditionalExpression{
+		tokens := append(append(append([]Token{}, left.Tokens()...), current), right.Tokens()...)
+		if current.Kind == KindArithmeticOperator {
+			left = ArithmeticExpression{
 				Left:     left,
 				Right:    right,
-				Operator: operator,
-				tokens: append(
-					append(leftTokens, current),
-					right.Tokens()...,
-				),
-			}, prec)
+				Operator: arithmetic,
+				tokens:   tokens,
+			}
+		} else {
+			left = ConditionalExpression{
+				Left:     left,
+				Right:    right,
+				Operator: binary,
+				tokens:   tokens,
+			}
+		}
+	}
+}
+
+// parseUnary parses an operand with its prefix operators: .not., a sign, or
+// parentheses around a whole expression.
+func (p *parser) parseUnary() (Node, error) {
+	current := p.tokenizer.Current()
 
+	switch {
+	case current.Kind == KindLogicalOperator && p.getBinaryOperator() == Not:
+		p.tokenizer.Next()
+
+		right, err := p.parseExpression(precedence[Not])
+		if err != nil {
+			return nil, err
 		}
 
-	} else if current.Kind == KindIdentifier ||
-		current.Kind == KindInteger ||
-		current.Kind == KindReal ||
-		current.Kind == KindString {
-		atom, err := p.parseAtom(current.Line)
+		return ConditionalExpression{
+			Right:    right,
+			Operator: Not,
+			tokens:   append([]Token{current}, right.Tokens()...),
+		}, nil
+	case current.Kind == KindArithmeticOperator && (current.Value == "-" || current.Value == "+"):
+		p.tokenizer.Next()
+
+		right, err := p.parseUnary()
 		if err != nil {
 			return nil, err
 		}
 
-		return p.parseExpresion(atom, prec)
+		if current.Value == "+" {
+			return right, nil
+		}
+
+		return ArithmeticExpression{
+			Right:    right,
+			Operator: ArithmeticSubtract,
+			tokens:   append([]Token{current}, right.Tokens()...),
+		}, nil
+	case current.Kind == KindLeftParenthesis:
+		p.tokenizer.Next()
+
+		expression, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+
+		closing := p.tokenizer.Current()
+		if closing.Kind != KindRightParenthesis {
+			return nil, errorAt(
+				closing,
+				[]Kind{KindRightParenthesis},
+				"expected ) to close ( at line %d column %d but found %q",
+				current.Line,
+				current.Column,
+				closing.Value,
+			)
+		}
+		p.tokenizer.Next()
+
+		return ParenthesizedExpression{
+			Expression: expression,
+			tokens:     append(append([]Token{current}, expression.Tokens()...), closing),
+		}, nil
 	}
 
-	return left, nil
+	return p.parseAtom()
 }
 
 func run(steps ...func() error) error {
@@ -338,59 +1060,134 @@ func run(steps ...func() error) error {
 	return err
 }
 
-func (p parser) parseIfStatement() (Node, error) {
+// parseIfStatement parses the one line form, if cond then statement, and
+// the block form:
+//
+//	if cond then
+//	...
+//	elseif cond then
+//	...
+//	else
+//	...
+//	endif
+func (p *parser) parseIfStatement() (Node, error) {
 
 	current := p.tokenizer.Current()
+	p.tokenizer.Next() // Eat if
 
-	var (
-		condition,
-		statement Node
-	)
-	err := run(func() error {
-		var err error
-		p.tokenizer.Next() // Eat  token
-		condition, err = p.parseExpresion(nil, 0)
-		return err
-	}, func() error {
+	condition, then, err := p.parseConditionThen()
+	if err != nil {
+		return nil, err
+	}
 
-		if p.tokenizer.Current().Kind != KindKeyword &&
-			strings.ToLower(p.tokenizer.Current().Value) == "then" {
-			return fmt.Errorf(
-				"Not valid if then statement line: %d coulumn: %d",
-				p.tokenizer.Current().Line,
-				p.tokenizer.Current().Column,
-			)
+	tokens := append(append([]Token{current}, condition.Tokens()...), then)
+
+	if p.tokenizer.Current().Line == then.Line {
+		p.blocks++
+		statement, err := p.parseStatement()
+		p.blocks--
+		if err != nil {
+			return nil, err
 		}
-		p.tokenizer.Next() // Eat then token
 
-		var err error
-		// statement, err = p.parseAtom(current.Line, true)
-		statement, err = p.parseStatement()
+		return IfStatement{
+			Condition: condition,
+			Then:      []Node{statement},
+			tokens:    append(tokens, statement.Tokens()...),
+		}, nil
+	}
 
-		return err
-	})
+	ifStatement := IfStatement{Condition: condition}
+
+	terminators := []string{"elseif", "else", "endif", "end if"}
+	body, err := p.parseBlock(terminators...)
 	if err != nil {
-		return nil, err
+		return nil, missingTerminator(err, "endif", current)
 	}
+	ifStatement.Then = body
+	tokens = appendTokens(tokens, body)
+
+	for p.atKeywords("elseif") {
+		elseif := p.tokenizer.Current()
+		p.tokenizer.Next()
 
-	cond, ok := condition.(ConditionalExpression)
-	if !ok {
-		return nil, fmt.Errorf("Invalid conditional expresion line %d", p.tokenizer.Current().Line)
+		condition, then, err := p.parseConditionThen()
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := p.parseBlock(terminators...)
+		if err != nil {
+			return nil, missingTerminator(err, "endif", current)
+		}
+
+		clause := ElseIfClause{
+			Condition: condition,
+			Body:      body,
+			tokens:    appendTokens(append(append([]Token{elseif}, condition.Tokens()...), then), body),
+		}
+		ifStatement.ElseIfs = append(ifStatement.ElseIfs, clause)
+		tokens = append(tokens, clause.Tokens()...)
 	}
 
-	return IfStatement{
-		Condition:     cond,
-		ThenStatement: statement,
-		tokens:        append(append([]Token{current}, condition.Tokens()...), statement.Tokens()...),
-	}, nil
+	if p.atKeywords("else") {
+		tokens = append(tokens, p.tokenizer.Current())
+		p.tokenizer.Next()
+
+		body, err := p.parseBlock("endif", "end if")
+		if err != nil {
+			return nil, missingTerminator(err, "endif", current)
+		}
+		ifStatement.Else = body
+		tokens = appendTokens(tokens, body)
+	}
+
+	if p.atKeywords("end", "if") {
+		tokens = append(tokens, p.tokenizer.Current())
+		p.tokenizer.Next()
+	}
+	tokens = append(tokens, p.tokenizer.Current())
+	p.tokenizer.Next() // Eat endif
+
+	ifStatement.tokens = tokens
+	return ifStatement, nil
 }
 
-func (p parser) parseEndStatement() (Node, error) {
+// parseConditionThen parses the condition of an if or elseif and the then
+// keyword after it.
+func (p *parser) parseConditionThen() (Node, Token, error) {
+	condition, err := p.parseCondition()
+	if err != nil {
+		return nil, Token{}, err
+	}
+
+	then, err := p.expectKeyword("then")
+	if err != nil {
+		return nil, Token{}, err
+	}
+
+	return condition, then, nil
+}
+
+// appendTokens appends the tokens of every statement.
+func appendTokens(tokens []Token, statements []Node) []Token {
+	for _, statement := range statements {
+		tokens = append(tokens, statement.Tokens()...)
+	}
+	return tokens
+}
+
+func (p *parser) parseEndStatement() (Node, error) {
 	current := p.tokenizer.Current()
 
+	if p.atKeywords("end", "sub") || p.atKeywords("end", "function") {
+		keyword := strings.ToLower(p.tokenizer.Peek().Value)
+		return nil, errorAt(current, nil, "end %s without %s", keyword, keyword)
+	}
+
 	p.tokenizer.Next()
 	if p.tokenizer.Current().Kind != KindPeriod {
-		return nil, fmt.Errorf("Not valid end statement. line: %d column %d", current.Line, current.Column)
+		return nil, errorAt(p.tokenizer.Current(), []Kind{KindPeriod}, "Not valid end statement")
 	}
 
 	return EndStatement{
@@ -398,7 +1195,7 @@ func (p parser) parseEndStatement() (Node, error) {
 	}, nil
 }
 
-func (p parser) parseRemStatement() (Node, error) {
+func (p *parser) parseRemStatement() (Node, error) {
 
 	tokens := []Token{}
 	line := p.tokenizer.Current().Line
@@ -414,7 +1211,7 @@ func (p parser) parseRemStatement() (Node, error) {
 	}, nil
 }
 
-func (p parser) parsePrintStatement() (Node, error) {
+func (p *parser) parsePrintStatement() (Node, error) {
 	current := p.tokenizer.Current()
 	p.tokenizer.Next()
 	argument, err := p.parsePrintArguments(current.Line)
@@ -433,64 +1230,128 @@ func (p parser) parsePrintStatement() (Node, error) {
 	}, nil
 }
 
-func (p parser) parseVariable() (Node, error) {
+func (p *parser) parseVariable() (Node, error) {
 	if p.tokenizer.Current().Kind != KindIdentifier {
-		return nil, fmt.Errorf("Not variable")
+		return nil, errorAt(p.tokenizer.Current(), []Kind{KindIdentifier}, "Not variable")
 	}
 
-	name := strings.ToLower(p.tokenizer.Current().Value)
 	current := p.tokenizer.Current()
-	tokens := []Token{current}
+	t, err := p.variableType(current)
+	if err != nil {
+		return nil, err
+	}
+	p.tokenizer.Next()
+
+	variable := Variable{
+		Type:   t,
+		Name:   strings.ToLower(current.Value),
+		tokens: []Token{current},
+	}
+
+	if p.tokenizer.Current().Kind == KindLeftParenthesis {
+		indexes, tokens, err := p.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+		variable.Indexes = indexes
+		variable.tokens = append(variable.tokens, tokens...)
+	}
+
+	return variable, nil
+}
 
+// variableType returns the type of the variable named by the identifier:
+// the declared type of a parameter, or its implicit type.
+func (p *parser) variableType(identifier Token) (VariableType, error) {
+	name := strings.ToLower(identifier.Value)
+
+	// Parameters declared with as keep their type in the body
+	if t, ok := p.declared[name]; ok {
+		return t, nil
+	}
+
+	if t := implicitType(name); t != 0 {
+		return t, nil
+	}
+
+	return 0, errorAt(identifier, nil, "Invalid identifier used as variable")
+}
+
+// implicitType returns the type given to a variable by the first letter of
+// its name, a-f integer, g-n real and the rest string, or 0 when the name
+// cannot be a variable.
+func implicitType(name string) VariableType {
 	switch {
+	case name == "":
+		return 0
 	case name[0] >= 'a' && name[0] <= 'f':
-		p.tokenizer.Next()
-		return Variable{
-			Type:   VariableInteger,
-			Name:   name,
-			tokens: tokens,
-		}, nil
+		return VariableInteger
 	case name[0] >= 'g' && name[0] <= 'n':
-		p.tokenizer.Next()
-		return Variable{
-			Type:   VariableReal,
-			Name:   name,
-			tokens: tokens,
-		}, nil
+		return VariableReal
 	case name[0] >= '0' && name[0] <= 'z':
-		p.tokenizer.Next()
-		return Variable{
-			Type:   VariableString,
-			Name:   name,
-			tokens: tokens,
-		}, nil
+		return VariableString
+	}
+	return 0
+}
+
+// checkBuiltinName rejects subs, functions and arrays named after a built-in
+// function.
+func checkBuiltinName(name Token) error {
+	if _, ok := builtins[strings.ToLower(name.Value)]; ok {
+		return errorAt(name, nil, "%s is a built-in function and cannot be redeclared", strings.ToLower(name.Value))
+	}
+	return nil
+}
+
+// parseDimStatement parses the declaration of an array with the bound of
+// each dimension, as in dim gm(3, 3).
+func (p *parser) parseDimStatement() (Node, error) {
+	current := p.tokenizer.Current()
+	p.tokenizer.Next() // Eat dim
+
+	name := p.tokenizer.Current()
+	if name.Kind != KindIdentifier || name.Line != current.Line {
+		return nil, errorAt(name, []Kind{KindIdentifier}, "expected array name after dim")
+	}
+
+	if err := checkBuiltinName(name); err != nil {
+		return nil, err
+	}
+
+	node, err := p.parseVariable()
+	if err != nil {
+		return nil, err
+	}
+	variable := node.(Variable)
+
+	if len(variable.Indexes) == 0 {
+		return nil, errorAt(name, []Kind{KindLeftParenthesis}, "dim %s needs the bounds of the array", variable.Name)
 	}
 
-	return nil, fmt.Errorf("Invalid identifier used as variable line: %d column: %d", p.tokenizer.Current().Line, p.tokenizer.Current().Column)
+	bounds := variable.Indexes
+	tokens := append([]Token{current}, variable.tokens...)
+	variable.Indexes = nil
+	variable.tokens = variable.tokens[:1]
+
+	p.arrays[variable.Name] = true
+
+	return DimStatement{
+		Variable: variable,
+		Bounds:   bounds,
+		tokens:   tokens,
+	}, nil
 }
 
-func (p parser) parsePrintArguments(line int) (Node, error) {
+func (p *parser) parsePrintArguments(line int) (Node, error) {
 	current := p.tokenizer.Current()
-	if current.Line != line {
+	if current.Line != line || current.Kind == KindEnd {
 		// Just print a new line
 		return nil, nil
 	}
 
-	switch current.Kind {
-	case KindKeyword:
-		if current.Line == line {
-			return nil, fmt.Errorf("Expected variable, string, number, or new line. Line: %d column: %d", current.Line, current.Column)
-		}
-		return nil, nil
-	case KindInteger:
-		return IntegerNumber{Value: current.Value, tokens: []Token{current}}, nil
-	case KindReal:
-		return RealNumber{Value: current.Value, tokens: []Token{current}}, nil
-	case KindString:
-		return String{Value: current.Value, tokens: []Token{current}}, nil
-	case KindIdentifier:
-		return p.parseVariable()
+	if current.Kind == KindKeyword {
+		return nil, errorAt(current, operandKinds, "Expected variable, string, number, or new line")
 	}
 
-	return nil, fmt.Errorf("Invalid print statement argument at line %d column %d", current.Line, current.Column)
+	return p.parseExpression(0)
 }
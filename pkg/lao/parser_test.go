@@ -21,6 +21,10 @@ func (t *fakeTokenizer) Next() bool {
 	return false
 }
 
+func (t *fakeTokenizer) Err() error {
+	return nil
+}
+
 func (t *fakeTokenizer) Current() lao.Token {
 	if t.position < 0 {
 		return lao.Token{}
@@ -88,3 +92,180 @@ func TestParser(t *testing.T) {
 		})
 	}
 }
+
+func TestParserErrors(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		err   string
+	}{
+		{
+			desc:  "next without for",
+			input: "print\nnext c",
+			err:   "next without for at line 2 column 1",
+		},
+		{
+			desc:  "missing next",
+			input: "for c = 1 to 10\nprint c",
+			err:   "unexpected end of input: missing next for the for statement at line 1 column 1",
+		},
+		{
+			desc:  "mismatched next",
+			input: "for c = 1 to 10\nprint c\nnext d",
+			err:   "next d does not match for c at line 3 column 6",
+		},
+		{
+			desc:  "missing wend",
+			input: "c = 1\nwhile c .lt. 3\nc = c .add. 1",
+			err:   "unexpected end of input: missing wend for the while statement at line 2 column 1",
+		},
+		{
+			desc:  "loop without do",
+			input: "loop until c .gt. 1",
+			err:   "loop without do at line 1 column 1",
+		},
+		{
+			desc:  "crossed loops",
+			input: "while c .lt. 3\nfor d = 1 to 2\nwend\nnext",
+			err:   "wend without while at line 3 column 1",
+		},
+		{
+			desc:  "exit outside of a loop",
+			input: "print\nexit",
+			err:   "exit outside of a loop at line 2 column 1",
+		},
+		{
+			desc:  "label inside a loop",
+			input: "for c = 1 to 3\nif c .eq. 2 then goto skip\nprint c\nskip:\nnext",
+			err:   "labels must be at the top level, not inside a block at line 4 column 1",
+		},
+		{
+			desc:  "label after then",
+			input: "if c .eq. 2 then skip:",
+			err:   "labels must be at the top level, not inside a block at line 1 column 18",
+		},
+		{
+			desc:  "missing end sub",
+			input: "sub greet(w)\nprint w",
+			err:   "unexpected end of input: missing end sub for the sub statement at line 1 column 1",
+		},
+		{
+			desc:  "end function without function",
+			input: "print\nend function",
+			err:   "end function without function at line 2 column 1",
+		},
+		{
+			desc:  "nested sub",
+			input: "sub outer()\nsub inner()\nend sub\nend sub",
+			err:   "sub must be declared at the top level at line 2 column 1",
+		},
+		{
+			desc:  "goto inside a sub",
+			input: "sub outer()\ngoto finish\nend sub",
+			err:   "goto is not allowed inside a sub at line 2 column 1",
+		},
+		{
+			desc:  "function return without value",
+			input: "function double(c)\nreturn\nend function",
+			err:   "return inside a function needs a value at line 2 column 1",
+		},
+		{
+			desc:  "missing endif",
+			input: "if c .eq. 1 then\nprint c\nelse\nprint",
+			err:   "unexpected end of input: missing endif for the if statement at line 1 column 1",
+		},
+		{
+			desc:  "else without if",
+			input: "print\nelse\nprint",
+			err:   "else without if at line 2 column 1",
+		},
+		{
+			desc:  "if without then",
+			input: "if c .eq. 1 print c",
+			err:   `expected then but found "print" at line 1 column 13`,
+		},
+		{
+			desc:  "unclosed parenthesis",
+			input: "a = (1 .add. 2\nprint a",
+			err:   `expected ) to close ( at line 1 column 5 but found "print" at line 2 column 1`,
+		},
+		{
+			desc:  "arithmetic used as a condition",
+			input: "if a .add. 1 then print a",
+			err:   "Invalid conditional expresion at line 1 column 4",
+		},
+		{
+			desc:  "string for counter",
+			input: "for w = 1 to 10\nnext w",
+			err:   "for counter w must be an integer or real variable at line 1 column 5",
+		},
+		{
+			desc:  "dim without bounds",
+			input: "dim a\nprint a",
+			err:   "dim a needs the bounds of the array at line 1 column 5",
+		},
+		{
+			desc:  "illegal character",
+			input: "a = 1\nprint a # 2",
+			err:   `illegal character "#" at line 2 column 9`,
+		},
+		{
+			desc:  "unterminated string",
+			input: "print \"hola\nprint 1",
+			err:   `unterminated string "hola at line 1 column 7`,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			p := lao.NewParser(lao.NewTokenizer(strings.NewReader(tC.input)))
+
+			_, err := p.Parse()
+			assert.EqualError(t, err, tC.err)
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	p := lao.NewParser(lao.NewTokenizer(strings.NewReader("a = 1\nfor c = 1 tox 3\nnext c")))
+
+	_, err := p.Parse()
+
+	parseError, ok := err.(*lao.ParseError)
+	if !ok {
+		t.Fatalf("expected a parse error but got %v", err)
+	}
+	assert.Equal(t, 2, parseError.Line)
+	assert.Equal(t, 11, parseError.Column)
+	assert.Equal(t, []lao.Kind{lao.KindKeyword}, parseError.Expected)
+	assert.Equal(t, lao.KindIdentifier, parseError.Found)
+	assert.Equal(t, "tox", parseError.Value)
+}
+
+func TestParserRecovery(t *testing.T) {
+	input := strings.Join([]string{
+		"a = 1",
+		"b = ",
+		"print a",
+		"if a .eq. 1 print a",
+		"for c = 1 to 3",
+		"dim a",
+		"print c",
+		"next c",
+		"d = (1",
+	}, "\n")
+	p := lao.NewParser(lao.NewTokenizer(strings.NewReader(input)), lao.WithRecovery())
+
+	nodes, err := p.Parse()
+
+	errs, ok := err.(lao.ParseErrors)
+	if !ok {
+		t.Fatalf("expected parse errors but got %v", err)
+	}
+
+	lines := []int{}
+	for _, e := range errs {
+		lines = append(lines, e.Line)
+	}
+	assert.Equal(t, []int{3, 4, 6, 9}, lines)
+	assert.Len(t, nodes, 3)
+}
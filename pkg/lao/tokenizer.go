@@ -1,8 +1,7 @@
 package lao
 
 import (
-	"bytes"
-	"fmt"
+	"bufio"
 	"io"
 	"strings"
 	"unicode"
@@ -26,121 +25,243 @@ const (
 	KindAssignment
 	KindLabel
 	KindEnd
+	KindLeftParenthesis
+	KindRightParenthesis
+	KindComma
+	KindIllegal
 )
 
-// Token from tokenizer.
+var kindNames = map[Kind]string{
+	KindIdentifier:         "identifier",
+	KindKeyword:            "keyword",
+	KindInteger:            "integer",
+	KindReal:               "real",
+	KindPeriod:             "period",
+	KindLogicalOperator:    "logical operator",
+	KindArithmeticOperator: "arithmetic operator",
+	KindRelationalOperator: "relational operator",
+	KindString:             "string",
+	KindAssignment:         "assignment",
+	KindLabel:              "label",
+	KindEnd:                "end of input",
+	KindLeftParenthesis:    "left parenthesis",
+	KindRightParenthesis:   "right parenthesis",
+	KindComma:              "comma",
+	KindIllegal:            "illegal token",
+}
+
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Token from tokenizer. Offset, Length, Leading and Trailing are only set by
+// a tokenizer created WithTrivia.
 type Token struct {
 	Kind   Kind
 	Value  string
 	Line   int
 	Column int
+	Offset int // bytes before the value in the source
+	Length int // bytes in the value
+
+	// Leading has the whitespace and newlines between the previous token
+	// and this one, and Trailing the spaces after this one on its line.
+	Leading  string
+	Trailing string
 }
 
-// Tokenizer takes in a stream of input and produces tokens
+// Tokenizer takes in a stream of input and produces tokens. Text that
+// starts no token, such as # or a string missing its closing quote, is
+// returned as a token of KindIllegal.
 type Tokenizer interface {
 	Current() Token
 	Next() bool
+	// Err returns the first error found in the input: an illegal token
+	// outside a rem comment, or a failure to read the input.
+	Err() error
 }
 
-// NewTokenizer creates a new tokenizier
-func NewTokenizer(r io.Reader) Tokenizer {
-	buf := new(bytes.Buffer)
+// eof is returned by peek when there are no runes left.
+const eof rune = -1
 
-	buf.ReadFrom(r)
+// TokenizerOption configures a tokenizer
+type TokenizerOption func(*tokenizer)
+
+// WithTrivia makes the tokenizer keep the text between tokens in their
+// Leading and Trailing fields, and set their Offset and Length. Joining the
+// Leading, Value and Trailing of every token, up to and including the
+// KindEnd one, gives back the UTF-8 source exactly. Offsets count the bytes
+// of the source even when it is not valid UTF-8.
+func WithTrivia() TokenizerOption {
+	return func(t *tokenizer) {
+		t.trivia = true
+	}
+}
+
+// NewTokenizer creates a new tokenizier. It reads runes from r as it needs
+// them, through a bufio.Reader unless r is already an io.RuneReader.
+func NewTokenizer(r io.Reader, options ...TokenizerOption) Tokenizer {
+	reader, ok := r.(io.RuneReader)
+	if !ok {
+		reader = bufio.NewReader(r)
+	}
 
-	return &tokenizer{
-		buf:    buf,
+	t := &tokenizer{
+		reader: reader,
 		line:   1,
 		column: 1,
 	}
+
+	for _, option := range options {
+		option(t)
+	}
+
+	return t
 }
 
 type tokenizer struct {
-	buf      *bytes.Buffer
-	ct       Token
-	position int
-	line     int
-	column   int
+	reader  io.RuneReader
+	ahead   []rune // runes read from reader but not consumed yet
+	sizes   []int  // bytes each rune ahead was read from
+	ct      Token
+	line    int
+	column  int
+	offset  int // bytes consumed
+	comment int // line of the last rem keyword
+	trivia  bool
+	err     error
 }
 
 func (t *tokenizer) Current() Token {
 	return t.ct
 }
-func (t *tokenizer) Next() bool {
-	if t.position >= t.buf.Len() {
-		t.ct = Token{Kind: KindEnd}
-		return false
-	}
-
-	t.skipWhitespaceAndNewLines()
 
-	ch := t.buf.Bytes()[t.position]
+func (t *tokenizer) Err() error {
+	return t.err
+}
 
-	if unicode.IsLetter(rune(ch)) {
+func (t *tokenizer) Next() bool {
+	leading := t.skipWhitespaceAndNewLines()
+	offset := t.offset
+
+	ch := t.peek(0)
+	switch {
+	case ch == eof:
+		t.ct = Token{Kind: KindEnd, Line: t.line, Column: t.column}
+	case unicode.IsLetter(ch):
 		t.recognizeKeywordsAndIdentifier()
-	}
-
-	if unicode.IsDigit(rune(ch)) {
+	case unicode.IsDigit(ch):
 		t.recognizeNumber()
-	}
-
-	if ch == '-' || ch == '+' {
-		t.recognizeNumber()
-	}
-
-	if ch == '.' {
+	case ch == '-' || ch == '+':
+		if !t.recognizeNumber() {
+			t.recognizeSign()
+		}
+	case ch == '.':
 		t.recognizeOperatorsAndPeriods()
+	case ch == '=':
+		t.accept(KindAssignment, 1)
+	case ch == '"':
+		t.recognizeString()
+	case ch == '(':
+		t.accept(KindLeftParenthesis, 1)
+	case ch == ')':
+		t.accept(KindRightParenthesis, 1)
+	case ch == ',':
+		t.accept(KindComma, 1)
+	default:
+		t.accept(KindIllegal, 1)
 	}
 
-	if ch == '=' {
-		t.recognizeAssignment()
+	switch {
+	case t.ct.Kind == KindKeyword && strings.ToLower(t.ct.Value) == "rem":
+		t.comment = t.ct.Line
+	case t.ct.Kind == KindIllegal && t.ct.Line != t.comment && t.err == nil:
+		t.err = illegalTokenError(t.ct)
 	}
 
-	if ch == '"' {
-		t.recognizeString()
+	if t.trivia {
+		t.ct.Offset = offset
+		t.ct.Length = t.offset - offset
+		t.ct.Leading = leading
+		t.ct.Trailing = t.skipSpaces()
 	}
 
-	return true
+	return t.ct.Kind != KindEnd
 }
 
-func (t *tokenizer) recognizeString() {
-	pos := t.position + 1
-	column := t.column
-
-	stringFinsihed := false
-	for pos < t.buf.Len() {
-		pos++
-		ch := t.buf.Bytes()[pos]
-		if ch == '"' {
-			stringFinsihed = true
-			break
+// peek returns the rune n places after the next one without consuming it,
+// or eof when the input ends before it.
+func (t *tokenizer) peek(n int) rune {
+	for len(t.ahead) <= n {
+		ch, size, err := t.reader.ReadRune()
+		if err != nil {
+			if err != io.EOF && t.err == nil {
+				t.err = err
+			}
+			return eof
 		}
+		t.ahead = append(t.ahead, ch)
+		t.sizes = append(t.sizes, size)
 	}
+	return t.ahead[n]
+}
 
-	if stringFinsihed {
-
-		b := make([]byte, pos-t.position+1)
+// accept consumes the next length runes as a token of the kind.
+func (t *tokenizer) accept(kind Kind, length int) {
+	t.ct = Token{
+		Kind:   kind,
+		Line:   t.line,
+		Column: t.column,
+	}
+	t.ct.Value = t.consume(length)
+}
 
-		read := copy(b, t.buf.Bytes()[t.position:])
-		_ = read
+// consume moves past the next length runes, which must have been peeked,
+// and returns them.
+func (t *tokenizer) consume(length int) string {
+	runes := t.ahead[:length]
+	for index, ch := range runes {
+		// Invalid UTF-8 reads as utf8.RuneError, whatever its size
+		t.offset += t.sizes[index]
+		if ch == '\n' {
+			t.line++
+			t.column = 1
+		} else {
+			t.column++
+		}
+	}
 
-		s := fmt.Sprintf("%s", b)
+	t.ahead = t.ahead[length:]
+	t.sizes = t.sizes[length:]
+	return string(runes)
+}
 
-		t.ct = Token{
-			Kind:   KindString,
-			Value:  s,
-			Column: column,
-			Line:   t.line,
+// recognizeString recognizes text between double quotes, which are kept in
+// the value. A string without its closing quote on the same line is an
+// illegal token.
+func (t *tokenizer) recognizeString() {
+	length := 1
+	for ch := t.peek(length); ch != '"'; ch = t.peek(length) {
+		if ch == eof || ch == '\n' {
+			t.accept(KindIllegal, length)
+			return
 		}
-		t.position += len(s)
-		t.column += len(s)
+		length++
 	}
+
+	t.accept(KindString, length+1)
 }
 
 func isKeyword(s string) bool {
 
 	switch s {
-	case "print", "rem", "if", "read", "then", "end", "goto":
+	case "print", "rem", "if", "read", "then", "end", "goto",
+		"for", "to", "step", "next", "while", "wend", "do", "loop",
+		"until", "exit", "gosub", "return", "sub", "function", "as",
+		"else", "elseif", "endif", "dim":
 		return true
 	}
 
@@ -148,104 +269,45 @@ func isKeyword(s string) bool {
 }
 
 func (t *tokenizer) recognizeKeywordsAndIdentifier() {
-
-	pos := t.position
-	column := t.column
-
-	identifier := ""
-	for pos < t.buf.Len() {
-		ch := t.buf.Bytes()[pos]
-
-		if !unicode.IsLetter(rune(ch)) && !unicode.IsNumber(rune(ch)) && ch != ':' {
-			break
-		}
-
-		identifier += string(ch)
-		pos++
+	length := 0
+	for ch := t.peek(0); unicode.IsLetter(ch) || unicode.IsNumber(ch) || ch == ':'; ch = t.peek(length) {
+		length++
 	}
-
-	if isKeyword(strings.ToLower(identifier)) {
-
-		t.ct = Token{
-			Kind:   KindKeyword,
-			Line:   t.line,
-			Column: column,
-			Value:  identifier,
-		}
-	} else if identifier[len(identifier)-1] == ':' {
-		t.ct = Token{
-			Kind:   KindLabel,
-			Line:   t.line,
-			Column: column,
-			Value:  identifier,
-		}
-	} else {
-		t.ct = Token{
-			Kind:   KindIdentifier,
-			Line:   t.line,
-			Column: column,
-			Value:  identifier,
-		}
+	identifier := string(t.ahead[:length])
+
+	switch {
+	case isKeyword(strings.ToLower(identifier)):
+		t.accept(KindKeyword, length)
+	case strings.HasSuffix(identifier, ":"):
+		t.accept(KindLabel, length)
+	default:
+		t.accept(KindIdentifier, length)
 	}
-
-	t.position = pos
-	t.column += len(identifier)
-
 }
 
+// recognizeOperatorsAndPeriods recognizes an operator such as .add., or a
+// period on its own when the letters after it do not make one.
 func (t *tokenizer) recognizeOperatorsAndPeriods() {
-
-	value := ""
-	line := t.line
-	colunm := t.column
-	// read till whitespace or newline
-	for i := t.position; i < t.buf.Len(); i++ {
-		ch := t.buf.Bytes()[i]
-
-		if unicode.IsSpace(rune(ch)) {
-			break
-		}
-
-		value += string(ch)
+	// read a period, the letters after it and the closing period
+	length := 1
+	for unicode.IsLetter(t.peek(length)) {
+		length++
+	}
+	if length == 1 || t.peek(length) != '.' {
+		t.accept(KindPeriod, 1)
+		return
 	}
+	length++
 
-	switch strings.ToLower(value) {
+	switch strings.ToLower(string(t.ahead[:length])) {
 	case ".add.", ".sub.", ".mul.", ".div.":
-		t.ct = Token{
-			Kind:   KindArithmeticOperator,
-			Value:  value,
-			Column: colunm,
-			Line:   line,
-		}
-		t.column += len(value)
-		t.position += len(value)
+		t.accept(KindArithmeticOperator, length)
 	case ".gt.", ".lt.", ".ge.", ".le.", ".eq.", ".ne.":
-		t.ct = Token{
-			Kind:   KindRelationalOperator,
-			Value:  value,
-			Column: colunm,
-			Line:   line,
-		}
-		t.column += len(value)
-		t.position += len(value)
+		t.accept(KindRelationalOperator, length)
 	case ".not.", ".and.", ".or.":
-		t.ct = Token{
-			Kind:   KindLogicalOperator,
-			Value:  value,
-			Column: colunm,
-			Line:   line,
-		}
-		t.column += len(value)
-		t.position += len(value)
-	case ".":
-		t.ct = Token{
-			Kind:   KindPeriod,
-			Value:  value,
-			Column: colunm,
-			Line:   line,
-		}
-		t.column += len(value)
-		t.position += len(value)
+		t.accept(KindLogicalOperator, length)
+	default:
+		t.accept(KindPeriod, 1)
 	}
 }
 
@@ -255,7 +317,6 @@ const (
 	initial numberState = iota
 	integer
 	beginSignedNumber
-	signedNumber
 	beginNumberWithFractionalPart
 	numberWithFractionalPart
 	beginNumberWithExponent
@@ -264,31 +325,24 @@ const (
 	noNextState
 )
 
-func (t *tokenizer) recognizeNumber() {
-
-	line := t.line
-	column := t.column
+func (t *tokenizer) recognizeNumber() bool {
 
-	nextState := func(currentState numberState, ch byte) numberState {
+	nextState := func(currentState numberState, ch rune) numberState {
 
 		switch currentState {
 		case initial:
-			if unicode.IsDigit(rune(ch)) {
+			if unicode.IsDigit(ch) {
 				return integer
 			}
 			if ch == '+' || ch == '-' {
 				return beginSignedNumber
 			}
-		case signedNumber:
-			if unicode.IsDigit(rune(ch)) {
-				return integer
-			}
 		case beginSignedNumber:
-			if unicode.IsDigit(rune(ch)) {
-				return signedNumber
+			if unicode.IsDigit(ch) {
+				return integer
 			}
 		case integer:
-			if unicode.IsDigit(rune(ch)) {
+			if unicode.IsDigit(ch) {
 				return integer
 			}
 
@@ -296,22 +350,22 @@ func (t *tokenizer) recognizeNumber() {
 				return beginNumberWithFractionalPart
 			}
 
-			if unicode.ToLower(rune(ch)) == 'e' {
+			if unicode.ToLower(ch) == 'e' {
 				return beginNumberWithExponent
 			}
 		case beginNumberWithFractionalPart:
-			if unicode.IsDigit(rune(ch)) {
+			if unicode.IsDigit(ch) {
 				return numberWithFractionalPart
 			}
 		case numberWithFractionalPart:
-			if unicode.IsDigit(rune(ch)) {
+			if unicode.IsDigit(ch) {
 				return numberWithFractionalPart
 			}
-			if unicode.ToLower(rune(ch)) == 'e' {
+			if unicode.ToLower(ch) == 'e' {
 				return beginNumberWithExponent
 			}
 		case numberWithExponent:
-			if unicode.IsDigit(rune(ch)) {
+			if unicode.IsDigit(ch) {
 				return numberWithExponent
 			}
 			if ch == '.' {
@@ -322,12 +376,12 @@ func (t *tokenizer) recognizeNumber() {
 				return beginNumberWithSignedExponent
 			}
 
-			if unicode.IsDigit(rune(ch)) {
+			if unicode.IsDigit(ch) {
 				return numberWithExponent
 			}
 
 		case beginNumberWithSignedExponent:
-			if unicode.IsDigit(rune(ch)) {
+			if unicode.IsDigit(ch) {
 				return numberWithExponent
 			}
 		}
@@ -347,26 +401,33 @@ func (t *tokenizer) recognizeNumber() {
 		return false
 	}
 
-	run := func() (bool, numberState, string) {
+	// run returns the longest prefix ending in an accepting state, so 1.add.
+	// is the number 1 followed by an operator
+	run := func() (bool, numberState, int) {
 		current := initial
-		number := ""
 
-		for i := t.position; i < t.buf.Len(); i++ {
-			ch := t.buf.Bytes()[i]
-			next := nextState(current, ch)
+		accepted := false
+		acceptedState := initial
+		acceptedLength := 0
+
+		for length := 0; ; length++ {
+			next := nextState(current, t.peek(length))
 
 			if next == noNextState {
 				break
 			}
-			number += string(ch)
 
 			current = next
+			if has(acceptingStates, current) {
+				accepted, acceptedState, acceptedLength = true, current, length+1
+			}
 		}
 
-		return has(acceptingStates, current), current, number
+		return accepted, acceptedState, acceptedLength
 	}
 
-	if isNumber, state, number := run(); isNumber {
+	isNumber, state, length := run()
+	if isNumber {
 
 		var kind Kind
 
@@ -377,42 +438,34 @@ func (t *tokenizer) recognizeNumber() {
 			kind = KindReal
 		}
 
-		t.ct = Token{
-			Kind:   kind,
-			Value:  number,
-			Line:   line,
-			Column: column,
-		}
-		t.position += len(number)
-		t.column += len(number)
+		t.accept(kind, length)
 	}
 
+	return isNumber
 }
 
-func (t *tokenizer) recognizeAssignment() {
-	if t.buf.Bytes()[t.position] == '=' {
-		t.ct = Token{
-			Kind:   KindAssignment,
-			Value:  "=",
-			Line:   t.line,
-			Column: t.column,
-		}
-		t.column++
-		t.position++
-	}
+// recognizeSign recognizes a + or - that is not part of a number, as in
+// -(a .add. b).
+func (t *tokenizer) recognizeSign() {
+	t.accept(KindArithmeticOperator, 1)
 }
 
-func (t *tokenizer) skipWhitespaceAndNewLines() {
+// skipWhitespaceAndNewLines moves past the whitespace before a token and
+// returns it.
+func (t *tokenizer) skipWhitespaceAndNewLines() string {
+	length := 0
+	for unicode.IsSpace(t.peek(length)) {
+		length++
+	}
+	return t.consume(length)
+}
 
-	for t.position < t.buf.Len() &&
-		(unicode.IsSpace(rune(t.buf.Bytes()[t.position])) ||
-			t.buf.Bytes()[t.position] == '\n') {
-		if t.buf.Bytes()[t.position] == '\n' {
-			t.line++
-			t.column = 1
-		} else {
-			t.column++
-		}
-		t.position++
+// skipSpaces moves past the whitespace after a token up to the end of its
+// line and returns it.
+func (t *tokenizer) skipSpaces() string {
+	length := 0
+	for ch := t.peek(0); ch != '\n' && ch != '\r' && unicode.IsSpace(ch); ch = t.peek(length) {
+		length++
 	}
+	return t.consume(length)
 }
@@ -86,6 +86,72 @@ func Test(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc:  "recognize signed numbers",
+			input: "-5 +12 -1.5",
+			expectedTokens: []lao.Token{
+				{
+					Kind:   lao.KindInteger,
+					Value:  "-5",
+					Line:   1,
+					Column: 1,
+				},
+				{
+					Kind:   lao.KindInteger,
+					Value:  "+12",
+					Line:   1,
+					Column: 4,
+				},
+				{
+					Kind:   lao.KindReal,
+					Value:  "-1.5",
+					Line:   1,
+					Column: 8,
+				},
+			},
+		},
+		{
+			desc:  "recognize parentheses, signs and operators without spaces",
+			input: "-(a.add.1)",
+			expectedTokens: []lao.Token{
+				{
+					Kind:   lao.KindArithmeticOperator,
+					Value:  "-",
+					Line:   1,
+					Column: 1,
+				},
+				{
+					Kind:   lao.KindLeftParenthesis,
+					Value:  "(",
+					Line:   1,
+					Column: 2,
+				},
+				{
+					Kind:   lao.KindIdentifier,
+					Value:  "a",
+					Line:   1,
+					Column: 3,
+				},
+				{
+					Kind:   lao.KindArithmeticOperator,
+					Value:  ".add.",
+					Line:   1,
+					Column: 4,
+				},
+				{
+					Kind:   lao.KindInteger,
+					Value:  "1",
+					Line:   1,
+					Column: 9,
+				},
+				{
+					Kind:   lao.KindRightParenthesis,
+					Value:  ")",
+					Line:   1,
+					Column: 10,
+				},
+			},
+		},
 		{
 			desc:  "recognize assignment",
 			input: "=",
@@ -188,6 +254,72 @@ func Test(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc:  "recognize utf-8 strings and identifiers",
+			input: "w = \"año ñ\"\nzaño = w",
+			expectedTokens: []lao.Token{
+				{
+					Kind:   lao.KindIdentifier,
+					Value:  "w",
+					Line:   1,
+					Column: 1,
+				},
+				{
+					Kind:   lao.KindAssignment,
+					Value:  "=",
+					Line:   1,
+					Column: 3,
+				},
+				{
+					Kind:   lao.KindString,
+					Value:  "\"año ñ\"",
+					Line:   1,
+					Column: 5,
+				},
+				{
+					Kind:   lao.KindIdentifier,
+					Value:  "zaño",
+					Line:   2,
+					Column: 1,
+				},
+				{
+					Kind:   lao.KindAssignment,
+					Value:  "=",
+					Line:   2,
+					Column: 6,
+				},
+				{
+					Kind:   lao.KindIdentifier,
+					Value:  "w",
+					Line:   2,
+					Column: 8,
+				},
+			},
+		},
+		{
+			desc:  "recognize illegal characters and unterminated strings",
+			input: "a;\n\"hola",
+			expectedTokens: []lao.Token{
+				{
+					Kind:   lao.KindIdentifier,
+					Value:  "a",
+					Line:   1,
+					Column: 1,
+				},
+				{
+					Kind:   lao.KindIllegal,
+					Value:  ";",
+					Line:   1,
+					Column: 2,
+				},
+				{
+					Kind:   lao.KindIllegal,
+					Value:  "\"hola",
+					Line:   2,
+					Column: 1,
+				},
+			},
+		},
 		{
 			desc:  "recognize relational operators",
 			input: ".eq. .lt. .ne. .le. .gt. .ge.",
@@ -248,3 +380,113 @@ func Test(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenizerErr(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+		err   string
+	}{
+		{
+			desc:  "valid input",
+			input: "print \"a\"",
+		},
+		{
+			desc:  "first illegal token",
+			input: "a = 1\nb = 2 * 3 # 4",
+			err:   `illegal character "*" at line 2 column 7`,
+		},
+		{
+			desc:  "unterminated string",
+			input: "w = \"abc\nprint w",
+			err:   `unterminated string "abc at line 1 column 5`,
+		},
+		{
+			desc:  "inside a rem comment",
+			input: "rem don't panic; \"really\nprint 1",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			tokenizer := lao.NewTokenizer(strings.NewReader(tC.input))
+			for tokenizer.Next() {
+			}
+
+			if tC.err == "" {
+				assert.NoError(t, tokenizer.Err())
+				return
+			}
+			assert.EqualError(t, tokenizer.Err(), tC.err)
+		})
+	}
+}
+
+func TestTokenizerTrivia(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+	}{
+		{
+			desc:  "indented blocks",
+			input: "for c = 1 to 3\n\tprint   c  \nnext c\n",
+		},
+		{
+			desc:  "comments, blank lines and windows newlines",
+			input: "  rem  año, \"ñ\"; #\r\n\r\n\r\na=b.add.-1   \r\n\n",
+		},
+		{
+			desc:  "illegal tokens",
+			input: "print \"hola\nend. #",
+		},
+		{
+			desc:  "only whitespace",
+			input: " \n\t",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			tokenizer := lao.NewTokenizer(strings.NewReader(tC.input), lao.WithTrivia())
+
+			source := ""
+			for more := true; more; {
+				more = tokenizer.Next()
+				token := tokenizer.Current()
+
+				assert.Equal(t, token.Value, tC.input[token.Offset:token.Offset+token.Length])
+				source += token.Leading + token.Value + token.Trailing
+			}
+
+			assert.Equal(t, tC.input, source)
+		})
+	}
+}
+
+func TestTokenizerTriviaInvalidUTF8(t *testing.T) {
+	input := "rem \xff\xfe\xef\xbf\xbd\nprint a"
+	tokenizer := lao.NewTokenizer(strings.NewReader(input), lao.WithTrivia())
+
+	var last lao.Token
+	for tokenizer.Next() {
+		last = tokenizer.Current()
+	}
+
+	assert.Equal(t, "a", last.Value)
+	assert.Equal(t, len(input)-1, last.Offset)
+}
+
+func TestTokenizerTriviaPlacement(t *testing.T) {
+	tokenizer := lao.NewTokenizer(strings.NewReader("a = 1 \n  print a"), lao.WithTrivia())
+
+	got := []lao.Token{}
+	for tokenizer.Next() {
+		got = append(got, tokenizer.Current())
+	}
+
+	assert.Equal(t, []lao.Token{
+		{Kind: lao.KindIdentifier, Value: "a", Line: 1, Column: 1, Offset: 0, Length: 1, Trailing: " "},
+		{Kind: lao.KindAssignment, Value: "=", Line: 1, Column: 3, Offset: 2, Length: 1, Trailing: " "},
+		{Kind: lao.KindInteger, Value: "1", Line: 1, Column: 5, Offset: 4, Length: 1, Trailing: " "},
+		{Kind: lao.KindKeyword, Value: "print", Line: 2, Column: 3, Offset: 9, Length: 5, Leading: "\n  ", Trailing: " "},
+		{Kind: lao.KindIdentifier, Value: "a", Line: 2, Column: 9, Offset: 15, Length: 1},
+	}, got)
+}
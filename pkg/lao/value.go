@@ -0,0 +1,343 @@
+package lao
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// ValueType is the type of a Value
+type ValueType int
+
+// ValueType
+const (
+	ValueNone ValueType = iota // the zero Value, held by undefined variables
+	ValueInteger
+	ValueReal
+	ValueString
+	ValueBoolean
+	ValueArray
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case ValueInteger:
+		return "integer"
+	case ValueReal:
+		return "real"
+	case ValueString:
+		return "string"
+	case ValueBoolean:
+		return "boolean"
+	case ValueArray:
+		return "array"
+	}
+	return "no value"
+}
+
+// Value is an integer, a real, a string, a boolean or an array. Booleans are
+// the results of conditions, and cannot be stored in variables.
+type Value struct {
+	typ    ValueType
+	bits   uint64      // integer, real or boolean, following the type
+	object interface{} // string or *Array
+}
+
+// IntegerValue creates an integer value.
+func IntegerValue(n int) Value {
+	return Value{typ: ValueInteger, bits: uint64(n)}
+}
+
+// RealValue creates a real value.
+func RealValue(x float64) Value {
+	return Value{typ: ValueReal, bits: math.Float64bits(x)}
+}
+
+// StringValue creates a string value.
+func StringValue(s string) Value {
+	return Value{typ: ValueString, object: s}
+}
+
+// BooleanValue creates a boolean value.
+func BooleanValue(b bool) Value {
+	if b {
+		return Value{typ: ValueBoolean, bits: 1}
+	}
+	return Value{typ: ValueBoolean}
+}
+
+// ArrayValue creates the value of a variable declared with dim.
+func ArrayValue(a *Array) Value {
+	return Value{typ: ValueArray, object: a}
+}
+
+// Type returns the type of the value.
+func (v Value) Type() ValueType {
+	return v.typ
+}
+
+// Integer returns the number of an integer value.
+func (v Value) Integer() int {
+	return int(v.bits)
+}
+
+// Real returns the number of a real value.
+func (v Value) Real() float64 {
+	return math.Float64frombits(v.bits)
+}
+
+// Boolean returns the truth of a boolean value.
+func (v Value) Boolean() bool {
+	return v.bits != 0
+}
+
+// Array returns the array of an array value.
+func (v Value) Array() *Array {
+	array, _ := v.object.(*Array)
+	return array
+}
+
+// String returns the text of a string value, and formats the other values
+// the way print does.
+func (v Value) String() string {
+	switch v.typ {
+	case ValueInteger:
+		return strconv.Itoa(v.Integer())
+	case ValueReal:
+		return fmt.Sprintf("%.6f", v.Real())
+	case ValueString:
+		return v.object.(string)
+	case ValueBoolean:
+		return strconv.FormatBool(v.Boolean())
+	case ValueArray:
+		return "array"
+	}
+	return ""
+}
+
+// number returns an integer or a real value as a real.
+func (v Value) number() float64 {
+	if v.typ == ValueInteger {
+		return float64(v.Integer())
+	}
+	return v.Real()
+}
+
+// coercions gives the type the operands of an arithmetic or relational
+// operator are converted to, by the types of the left and right operands.
+// Operands whose pair is missing cannot be combined.
+var coercions = [ValueArray + 1][ValueArray + 1]ValueType{
+	ValueInteger: {ValueInteger: ValueInteger, ValueReal: ValueReal},
+	ValueReal:    {ValueInteger: ValueReal, ValueReal: ValueReal},
+	ValueString:  {ValueString: ValueString},
+}
+
+// coerce converts two operands to the type given by the coercions, and
+// reports whether they can be combined.
+func coerce(left, right Value) (Value, Value, bool) {
+	switch coercions[left.typ][right.typ] {
+	case ValueNone:
+		return left, right, false
+	case ValueReal:
+		return RealValue(left.number()), RealValue(right.number()), true
+	}
+	return left, right, true
+}
+
+// valueType returns the type of the values held by variables of a type.
+func valueType(t VariableType) ValueType {
+	switch t {
+	case VariableInteger:
+		return ValueInteger
+	case VariableReal:
+		return ValueReal
+	case VariableString:
+		return ValueString
+	}
+	return ValueNone
+}
+
+// isNumber reports whether the value is an integer or a real.
+func (v Value) isNumber() bool {
+	return v.typ == ValueInteger || v.typ == ValueReal
+}
+
+// Add adds two numbers, or joins two strings. A number joined to a string is
+// formatted the way print does.
+func (v Value) Add(w Value) (Value, error) {
+	if v.typ == ValueString && (w.isNumber() || w.typ == ValueString) ||
+		w.typ == ValueString && v.isNumber() {
+		return StringValue(v.String() + w.String()), nil
+	}
+
+	l, r, ok := coerce(v, w)
+	switch {
+	case ok && l.typ == ValueInteger:
+		return IntegerValue(l.Integer() + r.Integer()), nil
+	case ok && l.typ == ValueReal:
+		return RealValue(l.Real() + r.Real()), nil
+	}
+	return Value{}, operandError("add", v, w)
+}
+
+// Subtract subtracts a number from another.
+func (v Value) Subtract(w Value) (Value, error) {
+	switch {
+	case v.typ == ValueString:
+		return Value{}, runtimeErrorf(ErrorType, "Cannot substract from string")
+	case w.typ == ValueString && v.isNumber():
+		return Value{}, runtimeErrorf(ErrorType, "Cannot substract string")
+	}
+
+	l, r, ok := coerce(v, w)
+	switch {
+	case ok && l.typ == ValueInteger:
+		return IntegerValue(l.Integer() - r.Integer()), nil
+	case ok && l.typ == ValueReal:
+		return RealValue(l.Real() - r.Real()), nil
+	}
+	return Value{}, operandError("subtract", v, w)
+}
+
+// Multiply multiplies two numbers.
+func (v Value) Multiply(w Value) (Value, error) {
+	if v.typ == ValueString || w.typ == ValueString && v.isNumber() {
+		return Value{}, runtimeErrorf(ErrorType, "Cannot multiply string")
+	}
+
+	l, r, ok := coerce(v, w)
+	switch {
+	case ok && l.typ == ValueInteger:
+		return IntegerValue(l.Integer() * r.Integer()), nil
+	case ok && l.typ == ValueReal:
+		return RealValue(l.Real() * r.Real()), nil
+	}
+	return Value{}, operandError("multiply", v, w)
+}
+
+// Divide divides a number by another. Integers divide to an integer,
+// rounding towards zero.
+func (v Value) Divide(w Value) (Value, error) {
+	if v.typ == ValueString || w.typ == ValueString && v.isNumber() {
+		return Value{}, runtimeErrorf(ErrorType, "Cannot divide string")
+	}
+
+	l, r, ok := coerce(v, w)
+	switch {
+	case ok && l.typ == ValueInteger:
+		if r.Integer() == 0 {
+			return Value{}, runtimeErrorf(ErrorArithmetic, "division by zero")
+		}
+		return IntegerValue(l.Integer() / r.Integer()), nil
+	case ok && l.typ == ValueReal:
+		return RealValue(l.Real() / r.Real()), nil
+	}
+	return Value{}, operandError("divide", v, w)
+}
+
+// Negate returns the opposite of a number.
+func (v Value) Negate() (Value, error) {
+	switch v.typ {
+	case ValueInteger:
+		return IntegerValue(-v.Integer()), nil
+	case ValueReal:
+		return RealValue(-v.Real()), nil
+	}
+	return Value{}, runtimeErrorf(ErrorType, "Cannot negate %s", v)
+}
+
+// Relate applies a relational operator to two numbers or two strings.
+func (v Value) Relate(operator BinaryOperator, w Value) (Value, error) {
+	var less, equal, greater bool
+
+	l, r, ok := coerce(v, w)
+	switch {
+	case ok && l.typ == ValueInteger:
+		less, equal, greater = l.Integer() < r.Integer(), l.Integer() == r.Integer(), l.Integer() > r.Integer()
+	case ok && l.typ == ValueReal:
+		less, equal, greater = l.Real() < r.Real(), l.Real() == r.Real(), l.Real() > r.Real()
+	case ok && l.typ == ValueString:
+		less, equal, greater = l.String() < r.String(), l.String() == r.String(), l.String() > r.String()
+	default:
+		return Value{}, runtimeErrorf(ErrorType, "cannot compare %s and %s", v.typ, w.typ)
+	}
+
+	switch operator {
+	case LessThan:
+		return BooleanValue(less), nil
+	case LessThanEqual:
+		return BooleanValue(less || equal), nil
+	case Equal:
+		return BooleanValue(equal), nil
+	case NotEqual:
+		return BooleanValue(!equal), nil
+	case GreaterThan:
+		return BooleanValue(greater), nil
+	case GreaterThanEqual:
+		return BooleanValue(greater || equal), nil
+	}
+	return Value{}, runtimeErrorf(ErrorType, "%s is not a relational operator", operator)
+}
+
+// And returns whether two booleans are both true.
+func (v Value) And(w Value) (Value, error) {
+	if v.typ != ValueBoolean || w.typ != ValueBoolean {
+		return Value{}, errNotBoolean()
+	}
+	return BooleanValue(v.Boolean() && w.Boolean()), nil
+}
+
+// Or returns whether either of two booleans is true.
+func (v Value) Or(w Value) (Value, error) {
+	if v.typ != ValueBoolean || w.typ != ValueBoolean {
+		return Value{}, errNotBoolean()
+	}
+	return BooleanValue(v.Boolean() || w.Boolean()), nil
+}
+
+// Not returns the opposite of a boolean.
+func (v Value) Not() (Value, error) {
+	if v.typ != ValueBoolean {
+		return Value{}, errNotBoolean()
+	}
+	return BooleanValue(!v.Boolean()), nil
+}
+
+func errNotBoolean() error {
+	return runtimeErrorf(ErrorType, "unable to convert expression to boolean")
+}
+
+// operandError is the error of an arithmetic operator given values it does
+// not apply to.
+func operandError(operation string, left, right Value) error {
+	return runtimeErrorf(ErrorType, "cannot %s %s and %s", operation, left.typ, right.typ)
+}
+
+// arithmetic applies an arithmetic operator to two values.
+func arithmetic(operator ArithmeticOperator, left, right Value) (Value, error) {
+	switch operator {
+	case ArithmeticAdd:
+		return left.Add(right)
+	case ArithmeticSubtract:
+		return left.Subtract(right)
+	case ArithmeticMultiplication:
+		return left.Multiply(right)
+	case ArithmeticDivision:
+		return left.Divide(right)
+	}
+	return Value{}, fmt.Errorf("unknown operator %s", operator)
+}
+
+// compare applies a relational or logical operator to two values. The left
+// value of .not. is ignored.
+func compare(operator BinaryOperator, left, right Value) (Value, error) {
+	switch operator {
+	case Not:
+		return right.Not()
+	case And:
+		return left.And(right)
+	case Or:
+		return left.Or(right)
+	}
+	return left.Relate(operator, right)
+}
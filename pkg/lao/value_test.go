@@ -0,0 +1,123 @@
+package lao_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vectorhacker/lao/pkg/lao"
+)
+
+func TestValueOperators(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		apply    func() (lao.Value, error)
+		expected lao.Value
+		err      string
+	}{
+		{
+			desc:     "integers stay integers",
+			apply:    func() (lao.Value, error) { return lao.IntegerValue(7).Divide(lao.IntegerValue(2)) },
+			expected: lao.IntegerValue(3),
+		},
+		{
+			desc:     "integers are coerced to reals",
+			apply:    func() (lao.Value, error) { return lao.IntegerValue(1).Add(lao.RealValue(0.5)) },
+			expected: lao.RealValue(1.5),
+		},
+		{
+			desc:     "strings are joined",
+			apply:    func() (lao.Value, error) { return lao.StringValue("a").Add(lao.StringValue("b")) },
+			expected: lao.StringValue("ab"),
+		},
+		{
+			desc:     "numbers are joined to strings as printed",
+			apply:    func() (lao.Value, error) { return lao.IntegerValue(1).Add(lao.StringValue("a")) },
+			expected: lao.StringValue("1a"),
+		},
+		{
+			desc:     "reals are joined to strings as printed",
+			apply:    func() (lao.Value, error) { return lao.StringValue("x").Add(lao.RealValue(2)) },
+			expected: lao.StringValue("x2.000000"),
+		},
+		{
+			desc:  "booleans cannot be added",
+			apply: func() (lao.Value, error) { return lao.BooleanValue(true).Add(lao.IntegerValue(1)) },
+			err:   "cannot add boolean and integer",
+		},
+		{
+			desc:  "strings cannot be subtracted",
+			apply: func() (lao.Value, error) { return lao.IntegerValue(1).Subtract(lao.StringValue("a")) },
+			err:   "Cannot substract string",
+		},
+		{
+			desc:  "integer division by zero",
+			apply: func() (lao.Value, error) { return lao.IntegerValue(1).Divide(lao.IntegerValue(0)) },
+			err:   "division by zero",
+		},
+		{
+			desc:     "negation",
+			apply:    func() (lao.Value, error) { return lao.RealValue(2.5).Negate() },
+			expected: lao.RealValue(-2.5),
+		},
+		{
+			desc:     "numbers are compared as reals",
+			apply:    func() (lao.Value, error) { return lao.IntegerValue(2).Relate(lao.LessThanEqual, lao.RealValue(2)) },
+			expected: lao.BooleanValue(true),
+		},
+		{
+			desc:     "strings are compared",
+			apply:    func() (lao.Value, error) { return lao.StringValue("b").Relate(lao.GreaterThan, lao.StringValue("a")) },
+			expected: lao.BooleanValue(true),
+		},
+		{
+			desc:     "not equal",
+			apply:    func() (lao.Value, error) { return lao.IntegerValue(1).Relate(lao.NotEqual, lao.RealValue(1.5)) },
+			expected: lao.BooleanValue(true),
+		},
+		{
+			desc:  "booleans cannot be compared",
+			apply: func() (lao.Value, error) { return lao.BooleanValue(true).Relate(lao.Equal, lao.BooleanValue(true)) },
+			err:   "cannot compare boolean and boolean",
+		},
+		{
+			desc:  "numbers and strings cannot be compared",
+			apply: func() (lao.Value, error) { return lao.IntegerValue(1).Relate(lao.Equal, lao.StringValue("1")) },
+			err:   "cannot compare integer and string",
+		},
+		{
+			desc:  "undefined values cannot be compared",
+			apply: func() (lao.Value, error) { return lao.Value{}.Relate(lao.Equal, lao.IntegerValue(1)) },
+			err:   "cannot compare no value and integer",
+		},
+		{
+			desc:     "logical operators",
+			apply:    func() (lao.Value, error) { return lao.BooleanValue(true).And(lao.BooleanValue(false)) },
+			expected: lao.BooleanValue(false),
+		},
+		{
+			desc:  "logical operators need booleans",
+			apply: func() (lao.Value, error) { return lao.IntegerValue(1).Not() },
+			err:   "unable to convert expression to boolean",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			value, err := tC.apply()
+			if tC.err != "" {
+				assert.EqualError(t, err, tC.err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tC.expected, value)
+		})
+	}
+}
+
+func TestValueString(t *testing.T) {
+	assert.Equal(t, "-3", lao.IntegerValue(-3).String())
+	assert.Equal(t, "0.500000", lao.RealValue(0.5).String())
+	assert.Equal(t, "text", lao.StringValue("text").String())
+	assert.Equal(t, "true", lao.BooleanValue(true).String())
+	assert.Equal(t, "", lao.Value{}.String())
+}
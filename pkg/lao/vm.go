@@ -0,0 +1,559 @@
+package lao
+
+import (
+	"context"
+	"io"
+)
+
+// vm runs the bytecode compiled from the statements it is given. It keeps
+// the semantics of the tree-walker, which remains the reference for how a
+// program behaves.
+type vm struct {
+	settings
+	compiler
+	budget
+	variables []Value // value of each global slot
+	stack     []Value
+	frames    []activation
+	memory    []Value // locals of every sub and function being run
+	locals    []Value // locals of the running sub or function
+	routine   *routine
+}
+
+// activation is a gosub, or a call to a sub or function, that has not
+// returned yet.
+type activation struct {
+	call    Node // gosub statement, call statement or call expression
+	caller  int  // address of the instruction that made the call
+	routine *routine
+	base    int // where the locals start in memory
+	result  Value
+	value   bool // the caller expects the result on the stack
+}
+
+func newVM(s settings) *vm {
+	return &vm{
+		settings: s,
+		compiler: newCompiler(),
+		budget:   budget{max: s.limits},
+	}
+}
+
+func (v *vm) Execute(statements []Node) error {
+	return v.ExecuteContext(context.Background(), statements)
+}
+
+func (v *vm) ExecuteContext(ctx context.Context, statements []Node) error {
+	// Variables outlive the labels and procedures of earlier programs
+	c := newCompiler()
+	c.globals, c.names = v.globals, v.names
+	v.compiler = c
+
+	return v.run(ctx, v.compile(statements))
+}
+
+func (v *vm) Continue(statements []Node) error {
+	return v.run(context.Background(), v.compile(statements))
+}
+
+func (v *vm) Variables() map[string]Value {
+	variables := map[string]Value{}
+	for slot, value := range v.variables {
+		if name := v.names[slot]; name != "" && value.Type() != ValueNone {
+			variables[name] = copyValue(value)
+		}
+	}
+	return variables
+}
+
+func (v *vm) Reset() {
+	v.compiler = newCompiler()
+	v.variables = nil
+	v.stack = nil
+	v.frames = nil
+	v.memory = nil
+	v.locals = nil
+	v.routine = nil
+	v.budget = budget{max: v.limits}
+}
+
+// run executes the code from start until it ends, and clears the stacks
+// whatever happened.
+func (v *vm) run(ctx context.Context, start int) error {
+	for len(v.variables) < len(v.names) {
+		v.variables = append(v.variables, Value{})
+	}
+	v.start(ctx)
+
+	err := v.execute(start)
+
+	v.stack = v.stack[:0]
+	v.frames = v.frames[:0]
+	for _, value := range v.memory {
+		v.release(value)
+	}
+	v.memory = v.memory[:0]
+	v.locals = nil
+	v.routine = nil
+	return err
+}
+
+func (v *vm) execute(pc int) error {
+	code := v.code
+	for pc < len(code) {
+		in := &code[pc]
+
+		switch in.op {
+		case opStep:
+			if err := v.step(pc); err != nil {
+				return v.fail(pc, err)
+			}
+			if v.debugger != nil {
+				if err := v.before(pc, in.a == 1); err != nil {
+					return v.fail(pc, err)
+				}
+			}
+
+		case opLiteral:
+			v.push(v.literals[in.a])
+
+		case opLoad:
+			value := v.lookup(in.a)
+			switch {
+			case value.Type() == ValueNone && loadMode(in.b) == loadPrint:
+				return v.fail(pc, runtimeErrorf(ErrorName, "Unable to find variable  %s", v.name(in.a)))
+			case value.Type() == ValueNone:
+				return v.fail(pc, runtimeErrorf(ErrorName, "No variable named %s", v.name(in.a)))
+			case value.Type() == ValueArray:
+				return v.fail(pc, runtimeErrorf(ErrorType, "array %s needs an index", v.name(in.a)))
+			}
+			v.push(value)
+
+		case opDefined:
+			if v.lookup(in.a).Type() == ValueNone {
+				return v.fail(pc, runtimeErrorf(ErrorName, "Unable to find variable  %s", v.name(in.a)))
+			}
+
+		case opElement:
+			array, offset, err := v.locate(in.a, v.pop(in.b))
+			if err != nil {
+				return v.fail(pc, err)
+			}
+			v.push(array.Elements[offset])
+
+		case opNegate:
+			top := len(v.stack) - 1
+			value, err := v.stack[top].Negate()
+			if err != nil {
+				return v.fail(pc, err)
+			}
+			v.stack[top] = value
+
+		case opNot:
+			top := len(v.stack) - 1
+			value, err := v.stack[top].Not()
+			if err != nil {
+				return v.fail(pc, err)
+			}
+			v.stack[top] = value
+
+		case opArithmetic:
+			right := v.pop(1)[0]
+			top := len(v.stack) - 1
+			value, err := arithmetic(ArithmeticOperator(in.a), v.stack[top], right)
+			if err == nil {
+				err = v.checkString(pc, value)
+			}
+			if err != nil {
+				return v.fail(pc, err)
+			}
+			v.stack[top] = value
+
+		case opCompare:
+			right := v.pop(1)[0]
+			top := len(v.stack) - 1
+			value, err := compare(BinaryOperator(in.a), v.stack[top], right)
+			if err != nil {
+				return v.fail(pc, err)
+			}
+			v.stack[top] = value
+
+		case opBuiltin:
+			b := v.constants[in.a].(*builtinCall)
+			if err := b.checkCount(b.name, in.b); err != nil {
+				return v.fail(pc, err)
+			}
+
+			arguments := v.pop(in.b)
+			for index, argument := range arguments {
+				if err := b.checkArgument(b.name, index, argument); err != nil {
+					return v.fail(pc, err)
+				}
+			}
+
+			value, err := b.call(&v.settings, arguments)
+			if err == nil {
+				err = v.checkString(pc, value)
+			}
+			if err != nil {
+				return v.fail(pc, err)
+			}
+			v.push(value)
+
+		case opCall:
+			next, err := v.call(pc, v.constants[in.a].(*callSite), in.b)
+			if err != nil {
+				return v.fail(pc, err)
+			}
+			pc = next
+			continue
+
+		case opResult:
+			v.frames[len(v.frames)-1].result = v.pop(1)[0]
+
+		case opReturn:
+			if len(v.frames) == 0 {
+				return v.fail(pc, runtimeErrorf(ErrorControl, "return without gosub"))
+			}
+
+			f := v.frames[len(v.frames)-1]
+			v.frames = v.frames[:len(v.frames)-1]
+			if f.routine != nil {
+				for _, value := range v.memory[f.base:] {
+					v.release(value)
+				}
+				v.memory = v.memory[:f.base]
+			}
+
+			v.locals, v.routine = nil, nil
+			if n := len(v.frames); n > 0 && v.frames[n-1].routine != nil {
+				outer := v.frames[n-1]
+				v.locals, v.routine = v.memory[outer.base:outer.base+len(outer.routine.names)], outer.routine
+			}
+
+			if f.routine != nil && f.routine.function {
+				// The call failed, not the statement that ended the function
+				if err := f.routine.checkResult(f.result); err != nil {
+					return v.fail(f.caller, err)
+				}
+				if f.value {
+					v.push(f.result)
+				}
+			}
+			pc = f.caller + 1
+			continue
+
+		case opAssignable:
+			if err := assignable(v.constants[in.a].(Variable), v.stack[len(v.stack)-1]); err != nil {
+				return v.fail(pc, err)
+			}
+
+		case opStore:
+			if v.slot(in.a).Type() == ValueArray {
+				return v.fail(pc, runtimeErrorf(ErrorType, "array %s needs an index", v.name(in.a)))
+			}
+			if err := v.assign(pc, in.a, v.pop(1)[0]); err != nil {
+				return v.fail(pc, err)
+			}
+
+		case opStoreElement:
+			indexes := v.pop(in.b)
+			array, offset, err := v.locate(in.a, indexes)
+			if err != nil {
+				return v.fail(pc, err)
+			}
+			array.Elements[offset] = v.pop(1)[0]
+
+		case opDim:
+			d := v.constants[in.a].(*dimension)
+			if v.slot(d.slot).Type() != ValueNone {
+				return v.fail(pc, runtimeErrorf(ErrorName, "%s is already defined", d.variable.Name))
+			}
+
+			bounds := make([]int, in.b)
+			for index, value := range v.pop(in.b) {
+				bound, err := arrayBound(d.variable.Name, value)
+				if err != nil {
+					return v.fail(pc, err)
+				}
+				bounds[index] = bound
+			}
+
+			if err := v.allocate(pc, d.variable.Name, bounds); err != nil {
+				return v.fail(pc, err)
+			}
+
+			created, err := newArray(d.variable.Name, d.variable.Type, bounds)
+			if err != nil {
+				return v.fail(pc, err)
+			}
+
+			array := ArrayValue(created)
+			if err := v.assign(pc, d.slot, array); err != nil {
+				v.release(array)
+				return v.fail(pc, err)
+			}
+
+		case opRead:
+			value, err := readValue(v.in, v.constants[in.a].(Variable))
+			if err == nil {
+				err = v.checkString(pc, value)
+			}
+			if err != nil {
+				return v.fail(pc, err)
+			}
+			v.push(value)
+
+		case opPrint:
+			if err := v.write(v.out, pc, v.pop(1)[0].String()); err != nil {
+				return v.fail(pc, err)
+			}
+
+		case opJump:
+			pc = in.a
+			continue
+
+		case opJumpUnless:
+			condition := v.pop(1)[0]
+			if condition.Type() != ValueBoolean {
+				return v.fail(pc, runtimeErrorf(ErrorType, "Invalid condition"))
+			}
+			if !condition.Boolean() {
+				pc = in.a
+				continue
+			}
+
+		case opGoto:
+			if in.a < 0 {
+				label := v.constants[in.b].(string)
+				return v.fail(pc, runtimeErrorf(ErrorName, "Unable to to goto label %s doesn't exist", label))
+			}
+			pc = in.a
+			continue
+
+		case opGosub:
+			gosub := v.constants[in.b].(GosubStatement)
+			if in.a < 0 {
+				return v.fail(pc, runtimeErrorf(ErrorName, "Unable to to gosub label %s doesn't exist", gosub.Label))
+			}
+			if len(v.frames) >= v.maxCallDepth {
+				return v.fail(pc, runtimeErrorf(ErrorStackOverflow, "gosub %s exceeds the maximum call depth of %d", gosub.Label, v.maxCallDepth))
+			}
+
+			v.frames = append(v.frames, activation{call: gosub, caller: pc})
+			pc = in.a
+			continue
+
+		case opForPrepare:
+			loop := v.constants[in.a].(*forLoop)
+			bounds := v.pop(3)
+			for index := range bounds {
+				value, err := forValue(loop.counter, bounds[index])
+				if err != nil {
+					return v.fail(pc, err)
+				}
+				bounds[index] = value
+			}
+
+			if bounds[2].number() == 0 {
+				return v.fail(pc, runtimeErrorf(ErrorArgument, "for counter %s has a step of zero", loop.counter.Name))
+			}
+
+			if err := v.assign(pc, loop.variable, bounds[0]); err != nil {
+				return v.fail(pc, err)
+			}
+			*v.slot(loop.to) = bounds[1]
+			*v.slot(loop.step) = bounds[2]
+
+		case opForTest:
+			loop := v.constants[in.a].(*forLoop)
+			if forFinished(*v.slot(loop.variable), *v.slot(loop.to), *v.slot(loop.step)) {
+				pc = in.b
+				continue
+			}
+
+		case opForNext:
+			loop := v.constants[in.a].(*forLoop)
+			counter := v.slot(loop.variable)
+			*counter = forStep(*counter, *v.slot(loop.step))
+			pc = in.b
+			continue
+
+		case opEnd:
+			return io.EOF
+
+		case opFail:
+			return v.fail(pc, v.constants[in.a].(error))
+		}
+
+		pc++
+	}
+
+	if len(v.frames) > 0 {
+		// A subroutine ran off the end of the program
+		return io.EOF
+	}
+	return nil
+}
+
+// before stops in the debugger at the step at pc.
+func (v *vm) before(pc int, test bool) error {
+	statement := v.statements[v.code[pc].statement]
+	if !pausesAt(statement, test) {
+		return nil
+	}
+	return v.debugger.Before(&vmPause{stopAt: stopAt{statement, test}, v: v, pc: pc})
+}
+
+// call starts a sub or function, or reads the element of an array that
+// parsed as a call, and returns the address to continue at.
+func (v *vm) call(pc int, site *callSite, count int) (int, error) {
+	r := v.routines[site.procedure]
+	if site.value && r != nil && !r.function {
+		return 0, runtimeErrorf(ErrorControl, "sub %s does not return a value", site.name)
+	}
+
+	arguments := v.pop(count)
+
+	// Arrays declared in an earlier input parse as calls
+	if site.value && r == nil {
+		if v.lookup(site.variable).Type() == ValueArray {
+			array, offset, err := v.locate(site.variable, arguments)
+			if err != nil {
+				return 0, err
+			}
+			v.push(array.Elements[offset])
+			return pc + 1, nil
+		}
+	}
+
+	if r == nil {
+		return 0, runtimeErrorf(ErrorName, "No sub or function named %s", site.name)
+	}
+
+	if err := r.checkCount(count); err != nil {
+		return 0, err
+	}
+
+	for index, argument := range arguments {
+		if err := r.checkArgument(index, argument); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(v.frames) >= v.maxCallDepth {
+		return 0, runtimeErrorf(ErrorStackOverflow, "call to %s exceeds the maximum call depth of %d", site.name, v.maxCallDepth)
+	}
+
+	base := len(v.memory)
+	v.memory = append(v.memory, make([]Value, len(r.names))...)
+	locals := v.memory[base:]
+	for index, argument := range arguments {
+		locals[r.arguments[index]] = argument
+	}
+
+	v.frames = append(v.frames, activation{
+		call:    site.node,
+		caller:  pc,
+		routine: r,
+		base:    base,
+		value:   site.value,
+	})
+	v.locals, v.routine = locals, r
+
+	return r.entry, nil
+}
+
+func (v *vm) push(value Value) {
+	v.stack = append(v.stack, value)
+}
+
+// pop removes the top count values of the stack and returns them in the
+// order they were pushed. They stay valid until the next push.
+func (v *vm) pop(count int) []Value {
+	top := len(v.stack) - count
+	values := v.stack[top:]
+	v.stack = v.stack[:top]
+	return values
+}
+
+// slot returns where the value of a variable slot is kept.
+func (v *vm) slot(slot int) *Value {
+	if slot < 0 {
+		return &v.locals[-slot-1]
+	}
+	return &v.variables[slot]
+}
+
+// assign sets a variable slot. New globals count against the maximum number
+// of variables.
+func (v *vm) assign(pc, slot int, value Value) error {
+	target := v.slot(slot)
+	if slot >= 0 && target.Type() == ValueNone {
+		if err := v.define(pc, v.names[slot]); err != nil {
+			return err
+		}
+	}
+
+	*target = value
+	return nil
+}
+
+// lookup returns the value of a variable, falling back to the global of the
+// same name for locals that are not set. Variables that are not defined have
+// no value.
+func (v *vm) lookup(slot int) Value {
+	if slot < 0 {
+		local := -slot - 1
+		if value := v.locals[local]; value.Type() != ValueNone {
+			return value
+		}
+		slot = v.routine.global[local]
+	}
+	return v.variables[slot]
+}
+
+func (v *vm) name(slot int) string {
+	if slot < 0 {
+		return v.routine.names[-slot-1]
+	}
+	return v.names[slot]
+}
+
+// locate finds the array of a variable and the position of the element at
+// the indexes in it.
+func (v *vm) locate(slot int, indexes []Value) (*Array, int, error) {
+	name := v.name(slot)
+	array, err := asArray(name, v.lookup(slot), len(indexes))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := 0
+	for dimension, value := range indexes {
+		index, err := array.index(name, dimension, value)
+		if err != nil {
+			return nil, 0, err
+		}
+		offset = offset*(array.Bounds[dimension]+1) + index
+	}
+
+	return array, offset, nil
+}
+
+// fail gives an error the position of the statement the instruction at pc
+// was compiled from, and the current call stack.
+func (v *vm) fail(pc int, err error) error {
+	runtimeError := unlocatedError(err)
+	if runtimeError == nil {
+		return err
+	}
+
+	calls := make([]Node, len(v.frames))
+	for index, f := range v.frames {
+		calls[index] = f.call
+	}
+
+	runtimeError.locate(v.statements[v.code[pc].statement], calls)
+	return runtimeError
+}